@@ -14,8 +14,10 @@ import (
 	"github.com/vinser/flibgo/pkg/config"
 	"github.com/vinser/flibgo/pkg/database"
 	"github.com/vinser/flibgo/pkg/genres"
+	"github.com/vinser/flibgo/pkg/normalize"
 	"github.com/vinser/flibgo/pkg/opds"
 	"github.com/vinser/flibgo/pkg/rlog"
+	"github.com/vinser/flibgo/pkg/search"
 	"github.com/vinser/flibgo/pkg/stock"
 
 	"golang.org/x/text/language"
@@ -27,8 +29,10 @@ func main() {
 
 	cfg := config.LoadConfig(configFile)
 	cfg.MkDirAll()
-	config.LoadLocales()
+	locales := config.LoadLocales()
 	langTag := language.Make(cfg.Language.DEFAULT)
+	// Default language goes first to be the fallback of UI language negotiation
+	langMatcher := language.NewMatcher(append([]language.Tag{langTag}, locales...))
 
 	stockLog := rlog.NewLog(cfg.Logs.SCAN, cfg.Logs.LEVEL)
 	defer stockLog.File.Close()
@@ -42,8 +46,12 @@ func main() {
 		f := "Book stock was inited. Tables were created in empty database"
 		stockLog.I.Println(f)
 	}
+	db.UpdateLanguageNames()
+	db.UpdateSearchKeys()
+	db.UpdateSerieKeys()
 
 	genresTree := genres.NewGenresTree(cfg.Genres.TREE_FILE)
+	suggestIndex := search.NewSuggestIndex()
 
 	stockHandler := &stock.Handler{
 		CFG: cfg,
@@ -54,12 +62,68 @@ func main() {
 
 	// Empty book stock database and then scan book stock directory to add books in book stock database
 	reindex := flag.Bool("reindex", false, "empty book stock database and then scan book stock directory to add books in book stock database")
+	// Merge author with canonical one, author is kept as alias of canonical author to survive reindex
+	mergeAuthor := flag.Int64("merge-author", 0, "merge author with given id into the author given by -into flag")
+	into := flag.Int64("into", 0, "canonical author or serie id to merge into")
+	authorAliases := flag.Bool("author-aliases", false, "print likely author aliases grouped by normalised surname and initial")
+	// Merge, rename and alias series, old serie names are kept as aliases to survive reindex
+	mergeSerie := flag.Int64("merge-serie", 0, "merge serie with given id into the serie given by -into flag")
+	renameSerie := flag.Int64("rename-serie", 0, "rename serie with given id to the name given by -name flag")
+	serieAlias := flag.String("serie-alias", "", "add serie alias name of the serie given by -name flag")
+	name := flag.String("name", "", "serie name to rename to or to add alias of")
 	flag.Parse()
 	if *reindex {
 		stockHandler.Reindex()
 		return
 	}
+	if *mergeAuthor != 0 {
+		if err := db.MergeAuthors(*mergeAuthor, *into); err != nil {
+			log.Fatal(err)
+		}
+		f := "author %d has been merged into %d\n"
+		stockLog.I.Printf(f, *mergeAuthor, *into)
+		log.Printf(f, *mergeAuthor, *into)
+		return
+	}
+	if *mergeSerie != 0 {
+		if err := db.MergeSeries(*mergeSerie, *into); err != nil {
+			log.Fatal(err)
+		}
+		f := "serie %d has been merged into %d\n"
+		stockLog.I.Printf(f, *mergeSerie, *into)
+		log.Printf(f, *mergeSerie, *into)
+		return
+	}
+	if *renameSerie != 0 {
+		if err := db.RenameSerie(*renameSerie, *name); err != nil {
+			log.Fatal(err)
+		}
+		f := "serie %d has been renamed to %q\n"
+		stockLog.I.Printf(f, *renameSerie, *name)
+		log.Printf(f, *renameSerie, *name)
+		return
+	}
+	if *serieAlias != "" {
+		if err := db.AddSerieAlias(*serieAlias, *name); err != nil {
+			log.Fatal(err)
+		}
+		f := "serie alias %q of %q has been added\n"
+		stockLog.I.Printf(f, *serieAlias, *name)
+		log.Printf(f, *serieAlias, *name)
+		return
+	}
+	if *authorAliases {
+		for _, authors := range db.AuthorAliasSuggestions() {
+			fmt.Println(normalize.NameKey(authors[0].Sort))
+			for _, a := range authors {
+				fmt.Printf("\t%d\t%s\t(%d)\n", a.ID, a.Sort, a.Count)
+			}
+		}
+		return
+	}
 
+	// Suggestions are available before the first scan of new acquisitions is finished
+	suggestIndex.Refresh(db)
 	stopScan := make(chan struct{})
 	go func() {
 		defer func() { stopScan <- struct{}{} }()
@@ -68,6 +132,7 @@ func main() {
 		log.Print(f)
 		for {
 			stockHandler.ScanDir(false)
+			suggestIndex.Refresh(db)
 			time.Sleep(time.Duration(cfg.Database.POLL_PERIOD) * time.Second)
 			select {
 			case <-stopScan:
@@ -84,6 +149,8 @@ func main() {
 		DB:  db,
 		GT:  genresTree,
 		P:   message.NewPrinter(langTag),
+		LM:  langMatcher,
+		SI:  suggestIndex,
 	}
 	portString := fmt.Sprint(":", cfg.OPDS.PORT)
 	server := &http.Server{
@@ -1,9 +1,11 @@
 SET FOREIGN_KEY_CHECKS=0;
 DROP TABLE IF EXISTS languages;
 DROP TABLE IF EXISTS authors;
+-- author_aliases table is kept to survive reindex
 DROP TABLE IF EXISTS books;
 DROP TABLE IF EXISTS genres;
 DROP TABLE IF EXISTS series;
+-- serie_aliases table is kept to survive reindex
 DROP TABLE IF EXISTS books_authors;
 DROP TABLE IF EXISTS books_genres;
 DROP TABLE IF EXISTS books_series;
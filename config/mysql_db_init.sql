@@ -7,7 +7,7 @@ DROP TABLE IF EXISTS languages;
 CREATE TABLE languages (
     id INTEGER   PRIMARY KEY AUTO_INCREMENT,
     code VARCHAR(8) NOT NULL,
-    name VARCHAR(16) NULL
+    name VARCHAR(64) NULL
 );
 CREATE INDEX languages_code_idx ON languages (code);
 CREATE INDEX languages_name_idx ON languages (name);
@@ -16,11 +16,22 @@ DROP TABLE IF EXISTS authors;
 CREATE TABLE authors (
     id INTEGER   PRIMARY KEY AUTO_INCREMENT,
     name VARCHAR(128) NOT NULL,
-    sort VARCHAR(128) NOT NULL
+    sort VARCHAR(128) NOT NULL,
+    search_key VARCHAR(256) NOT NULL DEFAULT ''
 );
 CREATE INDEX authots_name_idx ON authors (name);
 CREATE INDEX authots_sort_idx ON authors (sort);
 
+-- Author aliases are kept on reindex, so they are not dropped and created if not exist only
+CREATE TABLE IF NOT EXISTS author_aliases (
+    id INTEGER   PRIMARY KEY AUTO_INCREMENT,
+    alias VARCHAR(128) NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    sort VARCHAR(128) NOT NULL,
+    INDEX author_aliases_alias_idx (alias),
+    INDEX author_aliases_sort_idx (sort)
+);
+
 DROP TABLE IF EXISTS books;
 CREATE TABLE books (
     id INTEGER   PRIMARY KEY AUTO_INCREMENT,
@@ -31,6 +42,7 @@ CREATE TABLE books (
     format VARCHAR(8) NOT NULL,
     title VARCHAR(512) NOT NULL,
     sort VARCHAR(512) NOT NULL,
+    search_key VARCHAR(1024) NOT NULL DEFAULT '',
     year VARCHAR(4) NOT NULL,
     language_id INTEGER NOT NULL,
     plot VARCHAR(10000) NOT NULL,
@@ -47,9 +59,21 @@ CREATE INDEX book_updated_idx ON books (updated);
 DROP TABLE IF EXISTS series;
 CREATE TABLE series (
     id INTEGER   PRIMARY KEY AUTO_INCREMENT,
-    name VARCHAR(256) NOT NULL
+    name VARCHAR(256) NOT NULL,
+    serie_key VARCHAR(256) NOT NULL DEFAULT '',
+    search_key VARCHAR(512) NOT NULL DEFAULT ''
 );
 CREATE INDEX series_name_idx ON series (name);
+CREATE INDEX series_serie_key_idx ON series (serie_key);
+
+-- Serie aliases are kept on reindex, so they are not dropped and created if not exist only
+CREATE TABLE IF NOT EXISTS serie_aliases (
+    id INTEGER   PRIMARY KEY AUTO_INCREMENT,
+    alias_key VARCHAR(256) NOT NULL,
+    name VARCHAR(256) NOT NULL,
+    INDEX serie_aliases_alias_key_idx (alias_key),
+    INDEX serie_aliases_name_idx (name)
+);
 
 DROP TABLE IF EXISTS books_authors;
 CREATE TABLE books_authors (
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"golang.org/x/text/language"
@@ -19,6 +20,8 @@ type Config struct {
 		BOOK_STOCK       string `yaml:"BOOK_STOCK"`
 		NEW_ACQUISITIONS string `yaml:"NEW_ACQUISITIONS"`
 		TRASH            string `yaml:"TRASH"`
+		CACHE            string `yaml:"CACHE"`
+		CACHE_SIZE       int    `yaml:"CACHE_SIZE"`
 	}
 	Language struct {
 		DEFAULT string `yaml:"DEFAULT"`
@@ -40,9 +43,43 @@ type Config struct {
 		LEVEL string `yaml:"LEVEL"`
 	}
 	OPDS struct {
-		PORT      int `yaml:"PORT"`
-		PAGE_SIZE int `yaml:"PAGE_SIZE"`
+		PORT      int       `yaml:"PORT"`
+		PAGE_SIZE int       `yaml:"PAGE_SIZE"`
+		DEVICES   []*Device `yaml:"DEVICES"`
+		FILENAME  string    `yaml:"FILENAME"`
+		TRANSLIT  bool      `yaml:"TRANSLIT"`
 	}
+	Covers struct {
+		SIZES     map[string]uint `yaml:"SIZES"`
+		THUMBNAIL string          `yaml:"THUMBNAIL"`
+		QUALITY   int             `yaml:"QUALITY"`
+	}
+	Admin struct {
+		TOKEN string `yaml:"TOKEN"`
+	}
+}
+
+// Device is reader device profile with the book formats it prefers
+type Device struct {
+	NAME    string   `yaml:"NAME"`
+	AGENT   string   `yaml:"AGENT"`
+	ACCEPT  string   `yaml:"ACCEPT"`
+	FORMATS []string `yaml:"FORMATS"`
+	agent   *regexp.Regexp
+}
+
+// deviceFormats are the book format keys device profiles may list
+var deviceFormats = map[string]bool{"fb2": true, "fb2.zip": true, "epub": true, "mobi": true, "html": true, "txt": true, "txt-cp1251": true}
+
+// Matches reports whether the device profile matches User-Agent and Accept request headers
+func (d *Device) Matches(userAgent, accept string) bool {
+	if d.agent == nil && d.ACCEPT == "" {
+		return false
+	}
+	if d.agent != nil && !d.agent.MatchString(userAgent) {
+		return false
+	}
+	return d.ACCEPT == "" || strings.Contains(accept, d.ACCEPT)
 }
 
 func LoadConfig(configFile string) *Config {
@@ -59,6 +96,18 @@ func LoadConfig(configFile string) *Config {
 	if err := yaml.Unmarshal([]byte(b), c); err != nil {
 		log.Fatal(err)
 	}
+	for _, d := range c.OPDS.DEVICES {
+		if d.AGENT != "" {
+			if d.agent, err = regexp.Compile("(?i)" + d.AGENT); err != nil {
+				log.Fatalf("invalid device %s AGENT: %s", d.NAME, err)
+			}
+		}
+		for _, format := range d.FORMATS {
+			if !deviceFormats[format] {
+				log.Fatalf("invalid device %s FORMATS: unknown format %q", d.NAME, format)
+			}
+		}
+	}
 	return c
 }
 
@@ -74,9 +123,15 @@ func (cfg *Config) MkDirAll() {
 	if err := os.MkdirAll(cfg.Library.TRASH, 0666); err != nil {
 		log.Fatalf("failed to create Library TRASH directory %s: %s", cfg.Library.TRASH, err)
 	}
+	if len(cfg.Library.CACHE) > 0 {
+		if err := os.MkdirAll(cfg.Library.CACHE, 0666); err != nil {
+			log.Fatalf("failed to create Library CACHE directory %s: %s", cfg.Library.CACHE, err)
+		}
+	}
 }
 
-func LoadLocales() {
+// LoadLocales loads UI messages from locales directory and returns the locale languages
+func LoadLocales() []language.Tag {
 	dir := "locales"
 	// dir := "../locales"
 	files, err := ioutil.ReadDir(dir)
@@ -84,6 +139,7 @@ func LoadLocales() {
 		log.Fatal(err)
 	}
 
+	tags := []language.Tag{}
 	for _, f := range files {
 		if filepath.Ext(f.Name()) != ".yml" {
 			continue
@@ -103,5 +159,7 @@ func LoadLocales() {
 			message.SetString(lang, key, value)
 
 		}
+		tags = append(tags, lang)
 	}
+	return tags
 }
@@ -0,0 +1,210 @@
+// Package convert converts FB2 books to the formats readers without FB2 support can open
+package convert
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+)
+
+// Converter converts FB2 books
+type Converter struct {
+	// GenreName returns the genre name to be written to the book metadata.
+	// Genre codes are written when it is nil
+	GenreName func(code string) string
+}
+
+// book is FB2 document prepared for conversion
+type book struct {
+	data     []byte
+	doc      *fb2.Node
+	fb       *fb2.FB2
+	desc     *fb2.Description
+	binaries []*binary
+	images   map[string]*binary // binary id to binary
+	files    map[string]string  // element id to the file it is rendered to
+	notes    map[string]bool    // note ids
+	lang     string
+}
+
+type binary struct {
+	id        string
+	file      string
+	mediaType string
+	data      []byte
+}
+
+// readBook reads and parses FB2 book
+func readBook(r io.Reader) (*book, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := fb2.ParseTree(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	desc, err := fb2.NewDescription(bytes.NewReader(data))
+	if err != nil {
+		desc = &fb2.Description{}
+	}
+	b := &book{
+		data:   data,
+		doc:    doc,
+		fb:     &fb2.FB2{TitleInfo: &desc.TitleInfo},
+		desc:   desc,
+		images: map[string]*binary{},
+		files:  map[string]string{},
+		notes:  map[string]bool{},
+	}
+	b.lang = b.fb.GetLanguage().Code
+	if b.lang == "" {
+		b.lang = "und"
+	}
+	b.readBinaries()
+	return b, nil
+}
+
+func (b *book) readBinaries() {
+	names := map[string]bool{}
+	for _, n := range b.doc.ChildrenNamed("binary") {
+		id := n.AttrValue("id")
+		if id == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Map(dropSpace, n.Content()))
+		if err != nil {
+			continue
+		}
+		bin := &binary{id: id, mediaType: n.AttrValue("content-type"), data: data}
+		if bin.mediaType == "" {
+			bin.mediaType = imageType(id)
+		}
+		name := sanitizeID(strings.TrimSuffix(id, path.Ext(id))) + imageExt(bin.mediaType, id)
+		for i := 1; names[name]; i++ {
+			name = fmt.Sprint(i, "-", name)
+		}
+		names[name] = true
+		bin.file = "images/" + name
+		b.binaries = append(b.binaries, bin)
+		b.images[id] = bin
+	}
+}
+
+// mainBody returns the book body, not notes or comments one
+func (b *book) mainBody() *fb2.Node {
+	for _, body := range b.doc.ChildrenNamed("body") {
+		if !isNotesBody(body) {
+			return body
+		}
+	}
+	return &fb2.Node{Name: "body"}
+}
+
+// notesBodies returns the bodies of notes and comments
+func (b *book) notesBodies() []*fb2.Node {
+	bodies := []*fb2.Node{}
+	for _, body := range b.doc.ChildrenNamed("body") {
+		if isNotesBody(body) {
+			bodies = append(bodies, body)
+		}
+	}
+	return bodies
+}
+
+func isNotesBody(body *fb2.Node) bool {
+	name := body.AttrValue("name")
+	return name == "notes" || name == "comments"
+}
+
+// cover returns the cover image binary or nil
+func (b *book) cover() *binary {
+	return b.images[strings.TrimPrefix(b.desc.TitleInfo.CoverPage.Href, "#")]
+}
+
+// identifier returns the book identifier. It is FB2 document id or the one derived from the book content
+func (b *book) identifier() string {
+	if n := b.doc.Path("description", "document-info", "id"); n != nil {
+		if id := n.Content(); id != "" {
+			return "urn:fb2:" + id
+		}
+	}
+	h := sha1.Sum(b.data)
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+// annotation returns the book annotation as plain text
+func (b *book) annotation() string {
+	if n := b.doc.Path("description", "title-info", "annotation"); n != nil {
+		return n.Content()
+	}
+	return ""
+}
+
+// mapIDs records the file each element id of the node is rendered to
+func (b *book) mapIDs(n *fb2.Node, file string) {
+	if id := n.AttrValue("id"); id != "" {
+		b.files[id] = file
+	}
+	for _, c := range n.Children {
+		if !c.IsText() {
+			b.mapIDs(c, file)
+		}
+	}
+}
+
+var rxInvalidID = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// sanitizeID returns valid XML id of FB2 id
+func sanitizeID(id string) string {
+	id = rxInvalidID.ReplaceAllString(id, "_")
+	if id == "" || !(id[0] >= 'A' && id[0] <= 'Z' || id[0] >= 'a' && id[0] <= 'z' || id[0] == '_') {
+		id = "id" + id
+	}
+	return id
+}
+
+func imageType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	}
+	return "image/jpeg"
+}
+
+func imageExt(mediaType, name string) string {
+	switch mediaType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/jpeg", "image/jpg":
+		return ".jpg"
+	}
+	if ext := path.Ext(name); ext != "" {
+		return ext
+	}
+	return ".jpg"
+}
+
+func dropSpace(r rune) rune {
+	switch r {
+	case ' ', '\n', '\r', '\t':
+		return -1
+	}
+	return r
+}
+
+func esc(s string) string {
+	return html.EscapeString(s)
+}
@@ -0,0 +1,207 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+ <title-info>
+  <genre>sf_fantasy</genre>
+  <author><first-name>Лев</first-name><last-name>Толстой</last-name></author>
+  <book-title>Война &amp; мир</book-title>
+  <annotation><p>Роман-эпопея.</p></annotation>
+  <coverpage><image l:href="#cover.jpg"/></coverpage>
+  <lang>ru</lang>
+  <translator><first-name>Ivan</first-name><last-name>Petrov</last-name></translator>
+  <sequence name="Классика" number="2"/>
+ </title-info>
+ <document-info><id>test-book-1</id></document-info>
+ <publish-info><publisher>Наука</publisher><city>Москва</city><year>1980</year><isbn>5-02-000000-0</isbn></publish-info>
+</description>
+<body>
+ <title><p>Война и мир</p></title>
+ <epigraph><p>Эпиграф</p><text-author>Автор</text-author></epigraph>
+ <section id="ch1">
+  <title><p>Часть первая</p></title>
+  <p>Текст <emphasis>с</emphasis> примечанием<a l:href="#n1" type="note">[1]</a> и <a l:href="#ch2">ссылкой</a>.</p>
+  <section><title><p>Глава 1</p></title><p>Текст главы</p><image l:href="#cover.jpg"/></section>
+ </section>
+ <section id="ch2">
+  <title><p>Часть вторая</p></title>
+  <poem><stanza><v>Строка 1</v><v>Строка 2</v></stanza></poem>
+  <empty-line/>
+  <table><tr><th>A</th><td colspan="2">B</td></tr></table>
+ </section>
+</body>
+<body name="notes">
+ <title><p>Примечания</p></title>
+ <section id="n1"><title><p>1</p></title><p>Текст примечания</p></section>
+</body>
+<binary id="cover.jpg" content-type="image/jpeg">/9j/4AAQ</binary>
+</FictionBook>`
+
+func TestEPUB(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Converter{GenreName: func(code string) string { return "Фэнтези" }}
+	if err := c.EPUB(strings.NewReader(testFB2), buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store {
+		t.Errorf("mimetype is expected to be the first stored file, got: %s", zr.File[0].Name)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") || strings.HasSuffix(f.Name, ".ncx") {
+			if err := wellFormed(data); err != nil {
+				t.Errorf("%s is not well formed: %s", f.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/cover.xhtml", "OEBPS/chapter001.xhtml", "OEBPS/chapter002.xhtml", "OEBPS/chapter003.xhtml", "OEBPS/notes.xhtml", "OEBPS/images/cover.jpg"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	for name, expected := range map[string][]string{
+		"OEBPS/content.opf":      {"urn:fb2:test-book-1", "Война &amp; мир", "Лев Толстой", ">trl<", "Фэнтези", "Наука", "<dc:date>1980</dc:date>", "urn:isbn:5-02-000000-0", "Классика", "group-position\">2<", `properties="cover-image"`},
+		"OEBPS/chapter001.xhtml": {"<h1>Война и мир</h1>", `<blockquote class="epigraph">`},
+		"OEBPS/chapter002.xhtml": {`epub:type="noteref"`, `href="notes.xhtml#n1"`, `href="chapter003.xhtml#ch2"`, "<h3", `<img src="images/cover.jpg"`},
+		"OEBPS/chapter003.xhtml": {`<div class="poem">`, `<td colspan="2">B</td>`},
+		"OEBPS/notes.xhtml":      {`<aside epub:type="footnote" class="note" id="n1">`},
+		"OEBPS/nav.xhtml":        {"Часть первая", "Глава 1", "Примечания"},
+	} {
+		for _, s := range expected {
+			if !strings.Contains(files[name], s) {
+				t.Errorf("%s: expecting %q", name, s)
+			}
+		}
+	}
+}
+
+func wellFormed(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Converter{}
+	if err := c.HTML(strings.NewReader(testFB2), buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, s := range []string{`<html lang="ru">`, "<h1>Война &amp; мир</h1>", "Лев Толстой", `<nav class="toc">`, `href="#n1"`, `href="#ch2"`, `<img src="data:image/jpeg;base64,/9j/4AAQ"`, `<div class="note" id="n1">`, `<div class="poem">`} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expecting %q", s)
+		}
+	}
+	if strings.Contains(got, "epub:type") {
+		t.Error("epub:type is not expected in HTML")
+	}
+}
+
+func TestTXT(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Converter{}
+	if err := c.TXT(strings.NewReader(testFB2), buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, s := range []string{"Лев Толстой\nВойна & мир\n", "\nЧасть первая\n", "Текст с примечанием[1] и ссылкой.\n", textIndent + "Строка 1\n" + textIndent + "Строка 2\n", "A\tB\n", "1 Текст примечания\n"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("expecting %q in:\n%s", s, got)
+		}
+	}
+	if strings.Contains(got, "<") {
+		t.Error("markup is not expected in text")
+	}
+}
+
+func TestMOBI(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := &Converter{}
+	if err := c.MOBI(strings.NewReader(testFB2), buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if string(data[60:68]) != "BOOKMOBI" {
+		t.Fatalf("expecting BOOKMOBI, got: %q", data[60:68])
+	}
+	u16 := func(b []byte) int { return int(b[0])<<8 | int(b[1]) }
+	u32 := func(b []byte) int { return u16(b)<<16 | u16(b[2:]) }
+	n := u16(data[76:])
+	record := func(i int) []byte {
+		end := len(data)
+		if i+1 < n {
+			end = u32(data[78+8*(i+1):])
+		}
+		return data[u32(data[78+8*i:]):end]
+	}
+	r0 := record(0)
+	if string(r0[16:20]) != "MOBI" || string(r0[248:252]) != "EXTH" {
+		t.Fatal("MOBI and EXTH headers are expected")
+	}
+	if u32(r0[168:]) != 0xFFFFFFFF || u32(r0[180:]) != 0 {
+		t.Errorf("expecting no DRM, got offset: %#x, flags: %#x", u32(r0[168:]), u32(r0[180:]))
+	}
+	if u16(r0[192:]) != 1 || u32(r0[200:]) != u32(r0[208:])+1 || u32(r0[240:]) != 1 {
+		t.Errorf("unexpected first content: %d, FCIS: %d, FLIS: %d, extra flags: %d", u16(r0[192:]), u32(r0[200:]), u32(r0[208:]), u32(r0[240:]))
+	}
+	if string(record(u32(r0[200:]))[:4]) != "FCIS" || string(record(u32(r0[208:]))[:4]) != "FLIS" {
+		t.Error("FCIS and FLIS records are expected")
+	}
+	if name := string(r0[u32(r0[84:]) : u32(r0[84:])+u32(r0[88:])]); name != "Война & мир" {
+		t.Errorf("expecting full name, got: %q", name)
+	}
+	text := []byte{}
+	for i := 1; i <= u16(r0[8:]); i++ {
+		rec := record(i)
+		overlap := int(rec[len(rec)-1])
+		text = append(text, rec[:len(rec)-1-overlap]...)
+	}
+	if len(text) != u32(r0[4:]) {
+		t.Fatalf("expecting text length %d, got: %d", u32(r0[4:]), len(text))
+	}
+	if string(record(u32(r0[108:]))[:2]) != "\xff\xd8" {
+		t.Error("cover image record is expected")
+	}
+	html := string(text)
+	for _, s := range []string{"<mbp:pagebreak/>", `<img recindex="00001"`, `<reference type="toc"`, "Примечания"} {
+		if !strings.Contains(html, s) {
+			t.Errorf("expecting %q", s)
+		}
+	}
+	if strings.Contains(html, `href="#`) || strings.Contains(html, "<section") {
+		t.Error("links and sections are expected to be replaced")
+	}
+	i := strings.Index(html, "filepos=")
+	pos := 0
+	fmt.Sscanf(html[i+8:i+18], "%d", &pos)
+	if !strings.HasPrefix(html[pos:], `<div id="mobi-toc">`) {
+		t.Errorf("expecting link to contents, got: %q", html[pos:pos+20])
+	}
+}
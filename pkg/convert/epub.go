@@ -0,0 +1,336 @@
+package convert
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+)
+
+// EPUBType is EPUB publication media type
+const EPUBType = "application/epub+zip"
+
+// chapter is EPUB content document
+type chapter struct {
+	file  string
+	title string
+	nodes []*fb2.Node
+	depth int
+}
+
+// EPUB converts FB2 book read from r to EPUB 3 publication written to w.
+// Top level sections become separate content documents, notes become popup footnotes
+func (c *Converter) EPUB(r io.Reader, w io.Writer) error {
+	b, err := readBook(r)
+	if err != nil {
+		return err
+	}
+	b.markNotes()
+	chapters := b.chapters()
+	notes := b.notesBodies()
+	for _, ch := range chapters {
+		for _, n := range ch.nodes {
+			b.mapIDs(n, ch.file)
+		}
+	}
+	for _, body := range notes {
+		b.mapIDs(body, "notes.xhtml")
+	}
+	seq := 0
+	toc := []*tocItem{}
+	for _, ch := range chapters {
+		for _, n := range ch.nodes {
+			if n.Name != "section" {
+				continue
+			}
+			title := ""
+			if t := n.Child("title"); t != nil {
+				title = t.Content()
+			}
+			children := b.toc(n, ch.file, 2, &seq)
+			if title == "" {
+				toc = append(toc, children...)
+				continue
+			}
+			if n.AttrValue("id") == "" {
+				seq++
+				n.Attr = append(n.Attr, xmlAttr("id", fmt.Sprint("toc-", seq)))
+			}
+			toc = append(toc, &tocItem{title: title, href: ch.file + "#" + sanitizeID(n.AttrValue("id")), children: children})
+		}
+	}
+	if len(notes) > 0 {
+		title := "Notes"
+		if t := notes[0].Child("title"); t != nil && t.Content() != "" {
+			title = t.Content()
+		}
+		toc = append(toc, &tocItem{title: title, href: "notes.xhtml"})
+	}
+
+	zw := zip.NewWriter(w)
+	// mimetype goes first and uncompressed
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mw, EPUBType)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"META-INF/container.xml", containerXML},
+		{"OEBPS/style.css", stylesheet},
+		{"OEBPS/nav.xhtml", b.nav(toc)},
+		{"OEBPS/toc.ncx", b.ncx(toc)},
+	}
+	if cover := b.cover(); cover != nil {
+		files = append(files, struct{ name, content string }{"OEBPS/cover.xhtml", b.xhtml(b.fb.GetTitle(), `<div class="cover"><img src="`+esc(cover.file)+`" alt=""/></div>`)})
+	}
+	for _, ch := range chapters {
+		rr := b.epubRenderer()
+		for _, n := range ch.nodes {
+			rr.block(n, ch.depth)
+		}
+		files = append(files, struct{ name, content string }{"OEBPS/" + ch.file, b.xhtml(ch.title, rr.String())})
+	}
+	if len(notes) > 0 {
+		rr := b.epubRenderer()
+		rr.notes(notes)
+		files = append(files, struct{ name, content string }{"OEBPS/notes.xhtml", b.xhtml("Notes", rr.String())})
+	}
+	files = append(files, struct{ name, content string }{"OEBPS/content.opf", c.opf(b, chapters, len(notes) > 0)})
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.content); err != nil {
+			return err
+		}
+	}
+	for _, bin := range b.binaries {
+		fw, err := zw.Create("OEBPS/" + bin.file)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(bin.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (b *book) epubRenderer() *renderer {
+	return &renderer{
+		b:    b,
+		epub: true,
+		link: func(id string) string {
+			if file, ok := b.files[id]; ok {
+				return file + "#" + sanitizeID(id)
+			}
+			return ""
+		},
+		imageSrc: func(bin *binary) string { return bin.file },
+	}
+}
+
+// chapters splits the main body to content documents. Body title, epigraphs and images
+// make the title page and every top level section makes a chapter
+func (b *book) chapters() []*chapter {
+	chapters := []*chapter{}
+	var front *chapter
+	for _, n := range b.mainBody().Children {
+		switch {
+		case n.Name == "section":
+			front = nil
+			title := ""
+			if t := n.Child("title"); t != nil {
+				title = t.Content()
+			}
+			chapters = append(chapters, &chapter{file: fmt.Sprintf("chapter%03d.xhtml", len(chapters)+1), title: title, nodes: []*fb2.Node{n}})
+		case n.IsText() && strings.TrimSpace(n.Text) == "":
+		default:
+			if front == nil {
+				front = &chapter{file: fmt.Sprintf("chapter%03d.xhtml", len(chapters)+1), title: b.fb.GetTitle(), depth: 0}
+				chapters = append(chapters, front)
+			}
+			front.nodes = append(front.nodes, n)
+		}
+	}
+	if len(chapters) == 0 {
+		chapters = append(chapters, &chapter{file: "chapter001.xhtml", title: b.fb.GetTitle()})
+	}
+	return chapters
+}
+
+// xhtml returns XHTML content document
+func (b *book) xhtml(title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + esc(b.lang) + `" lang="` + esc(b.lang) + `">
+<head>
+<meta charset="UTF-8"/>
+<title>` + esc(title) + `</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+// nav returns EPUB 3 navigation document
+func (b *book) nav(toc []*tocItem) string {
+	var sb strings.Builder
+	sb.WriteString(`<nav epub:type="toc" id="toc">` + "\n<h1>" + esc(b.fb.GetTitle()) + "</h1>\n")
+	if len(toc) == 0 {
+		toc = []*tocItem{{title: b.fb.GetTitle(), href: "chapter001.xhtml"}}
+	}
+	tocList(&sb, toc)
+	sb.WriteString("</nav>\n")
+	return b.xhtml(b.fb.GetTitle(), sb.String())
+}
+
+// ncx returns EPUB 2 table of contents for older readers
+func (b *book) ncx(toc []*tocItem) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="` + esc(b.identifier()) + `"/></head>
+<docTitle><text>` + esc(b.fb.GetTitle()) + `</text></docTitle>
+<navMap>
+`)
+	order := 0
+	var points func(items []*tocItem)
+	points = func(items []*tocItem) {
+		for _, item := range items {
+			order++
+			sb.WriteString(fmt.Sprintf(`<navPoint id="nav%d" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/>`, order, order, esc(item.title), esc(item.href)))
+			points(item.children)
+			sb.WriteString("</navPoint>\n")
+		}
+	}
+	points(toc)
+	sb.WriteString("</navMap>\n</ncx>\n")
+	return sb.String()
+}
+
+var rxYear = regexp.MustCompile(`^\d{4}$`)
+
+// opf returns EPUB package document with the book metadata
+func (c *Converter) opf(b *book, chapters []*chapter, hasNotes bool) string {
+	var sb strings.Builder
+	meta := func(s string) { sb.WriteString(s + "\n") }
+	meta(`<?xml version="1.0" encoding="UTF-8"?>`)
+	meta(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="` + esc(b.lang) + `">`)
+	meta(`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	meta(`<dc:identifier id="uid">` + esc(b.identifier()) + `</dc:identifier>`)
+	meta(`<dc:title>` + esc(b.fb.GetTitle()) + `</dc:title>`)
+	meta(`<dc:language>` + esc(b.lang) + `</dc:language>`)
+	for i, a := range b.fb.GetAuthors() {
+		id := fmt.Sprint("aut", i+1)
+		meta(`<dc:creator id="` + id + `">` + esc(a.Name) + `</dc:creator>`)
+		meta(`<meta refines="#` + id + `" property="role" scheme="marc:relators">aut</meta>`)
+		meta(`<meta refines="#` + id + `" property="file-as">` + esc(a.Sort) + `</meta>`)
+	}
+	for i, t := range b.fb.GetTranslators() {
+		id := fmt.Sprint("trl", i+1)
+		meta(`<dc:contributor id="` + id + `">` + esc(t.Name) + `</dc:contributor>`)
+		meta(`<meta refines="#` + id + `" property="role" scheme="marc:relators">trl</meta>`)
+	}
+	if annotation := b.annotation(); annotation != "" {
+		meta(`<dc:description>` + esc(annotation) + `</dc:description>`)
+	}
+	for _, g := range b.fb.GetGenres() {
+		g = strings.TrimSpace(g)
+		if c.GenreName != nil {
+			if name := c.GenreName(g); name != "" {
+				g = name
+			}
+		}
+		meta(`<dc:subject>` + esc(g) + `</dc:subject>`)
+	}
+	pi := b.desc.PublishInfo
+	if publisher := strings.TrimSpace(pi.Publisher); publisher != "" {
+		meta(`<dc:publisher>` + esc(publisher) + `</dc:publisher>`)
+	}
+	year := strings.TrimSpace(pi.Year)
+	if !rxYear.MatchString(year) {
+		year = strings.TrimSpace(b.fb.GetYear())
+	}
+	if rxYear.MatchString(year) {
+		meta(`<dc:date>` + year + `</dc:date>`)
+	}
+	if isbn := strings.TrimSpace(pi.ISBN); isbn != "" {
+		meta(`<dc:identifier id="isbn">urn:isbn:` + esc(isbn) + `</dc:identifier>`)
+	}
+	if src := strings.TrimSpace(b.desc.TitleInfo.SrcLang); src != "" {
+		meta(`<dc:source>` + esc(src) + `</dc:source>`)
+	}
+	if serie := b.fb.GetSerie(); serie.Name != "" {
+		meta(`<meta property="belongs-to-collection" id="serie">` + esc(serie.Name) + `</meta>`)
+		meta(`<meta refines="#serie" property="collection-type">series</meta>`)
+		if n := b.fb.GetSerieNumber(); n > 0 {
+			meta(fmt.Sprint(`<meta refines="#serie" property="group-position">`, n, `</meta>`))
+		}
+	}
+	meta(`<meta property="dcterms:modified">` + time.Now().UTC().Format("2006-01-02T15:04:05Z") + `</meta>`)
+	cover := b.cover()
+	if cover != nil {
+		meta(`<meta name="cover" content="cover-image"/>`)
+	}
+	meta(`</metadata>`)
+
+	meta(`<manifest>`)
+	meta(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`)
+	meta(`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`)
+	meta(`<item id="css" href="style.css" media-type="text/css"/>`)
+	if cover != nil {
+		meta(`<item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>`)
+	}
+	for i, ch := range chapters {
+		meta(fmt.Sprintf(`<item id="chapter%d" href="%s" media-type="application/xhtml+xml"/>`, i+1, ch.file))
+	}
+	if hasNotes {
+		meta(`<item id="notes" href="notes.xhtml" media-type="application/xhtml+xml"/>`)
+	}
+	for i, bin := range b.binaries {
+		id, props := fmt.Sprint("img", i+1), ""
+		if bin == cover {
+			id, props = "cover-image", ` properties="cover-image"`
+		}
+		meta(`<item id="` + id + `" href="` + esc(bin.file) + `" media-type="` + esc(bin.mediaType) + `"` + props + `/>`)
+	}
+	meta(`</manifest>`)
+
+	meta(`<spine toc="ncx">`)
+	if cover != nil {
+		meta(`<itemref idref="cover"/>`)
+	}
+	for i := range chapters {
+		meta(fmt.Sprintf(`<itemref idref="chapter%d"/>`, i+1))
+	}
+	if hasNotes {
+		meta(`<itemref idref="notes"/>`)
+	}
+	meta(`</spine>`)
+	meta(`</package>`)
+	return sb.String()
+}
+
+func xmlAttr(name, value string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`
@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+)
+
+// HTMLType is single file HTML document media type
+const HTMLType = "text/html; charset=utf-8"
+
+// HTML converts FB2 book read from r to single HTML document written to w.
+// Images are embedded as data URIs, notes are placed at the end of the document
+func (c *Converter) HTML(r io.Reader, w io.Writer) error {
+	b, err := readBook(r)
+	if err != nil {
+		return err
+	}
+	b.markNotes()
+	for _, body := range b.doc.ChildrenNamed("body") {
+		b.mapIDs(body, "")
+	}
+	seq := 0
+	toc := b.toc(b.mainBody(), "", 2, &seq)
+	notes := b.notesBodies()
+	rr := &renderer{
+		b: b,
+		link: func(id string) string {
+			if _, ok := b.files[id]; ok {
+				return "#" + sanitizeID(id)
+			}
+			return ""
+		},
+		imageSrc: func(bin *binary) string {
+			return "data:" + bin.mediaType + ";base64," + base64.StdEncoding.EncodeToString(bin.data)
+		},
+	}
+	rr.write(`<header class="title">` + "\n")
+	if cover := b.cover(); cover != nil {
+		rr.write(`<div class="cover"><img src="`, esc(rr.imageSrc(cover)), `" alt=""/></div>`+"\n")
+	}
+	authors := []string{}
+	for _, a := range b.fb.GetAuthors() {
+		authors = append(authors, a.Name)
+	}
+	if len(authors) > 0 {
+		rr.write(`<p class="subtitle">`, esc(strings.Join(authors, ", ")), "</p>\n")
+	}
+	rr.write("<h1>", esc(b.fb.GetTitle()), "</h1>\n")
+	if n := b.doc.Path("description", "title-info", "annotation"); n != nil {
+		rr.block(n, 1)
+	}
+	rr.write("</header>\n")
+	if len(toc) > 0 {
+		rr.write(`<nav class="toc">` + "\n")
+		tocList(&rr.sb, toc)
+		rr.write("</nav>\n")
+	}
+	rr.blocks(b.mainBody(), 0)
+	rr.notes(notes)
+
+	_, err = io.WriteString(w, `<!DOCTYPE html>
+<html lang="`+esc(b.lang)+`">
+<head>
+<meta charset="utf-8"/>
+<title>`+esc(b.fb.GetTitle())+`</title>
+<style>
+`+stylesheet+`</style>
+</head>
+<body>
+`+rr.String()+`</body>
+</html>
+`)
+	return err
+}
+
+// tocList writes table of contents items as nested ordered lists
+func tocList(sb *strings.Builder, items []*tocItem) {
+	sb.WriteString("<ol>\n")
+	for _, item := range items {
+		sb.WriteString(`<li><a href="` + esc(item.href) + `">` + esc(item.title) + "</a>")
+		if len(item.children) > 0 {
+			tocList(sb, item.children)
+		}
+		sb.WriteString("</li>\n")
+	}
+	sb.WriteString("</ol>\n")
+}
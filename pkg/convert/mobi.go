@@ -0,0 +1,384 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+)
+
+// MOBIType is Mobipocket book media type
+const MOBIType = "application/x-mobipocket-ebook"
+
+const (
+	mobiRecordSize   = 4096
+	mobiHeaderLength = 232
+	mobiNone         = 0xFFFFFFFF
+)
+
+// MOBI converts FB2 book read from r to Mobipocket (MOBI 6) book written to w for Kindle readers.
+// Top level sections start new pages, table of contents follows the title page
+func (c *Converter) MOBI(r io.Reader, w io.Writer) error {
+	b, err := readBook(r)
+	if err != nil {
+		return err
+	}
+	b.markNotes()
+	for _, body := range b.doc.ChildrenNamed("body") {
+		b.mapIDs(body, "")
+	}
+	images, recindex := b.mobiImages()
+	text := []byte(mobiLinks(b.mobiHTML(recindex)))
+
+	records := [][]byte{nil}
+	for pos := 0; pos < len(text); pos += mobiRecordSize {
+		end := pos + mobiRecordSize
+		if end > len(text) {
+			end = len(text)
+		}
+		// Bytes of the character split by the record end are repeated as multibyte trailing entry
+		overlap := 0
+		for end+overlap < len(text) && !utf8.RuneStart(text[end+overlap]) {
+			overlap++
+		}
+		rec := append([]byte{}, text[pos:end+overlap]...)
+		records = append(records, append(rec, byte(overlap)))
+	}
+	textRecords := len(records) - 1
+	firstImage := uint32(mobiNone)
+	if len(images) > 0 {
+		firstImage = uint32(len(records))
+	}
+	records = append(records, images...)
+	flis := len(records)
+	records = append(records, mobiFLIS())
+	records = append(records, mobiFCIS(len(text)))
+	records = append(records, []byte{0xE9, 0x8E, 0x0D, 0x0A})
+	records[0] = c.mobiHeader(b, len(text), textRecords, firstImage, flis, recindex)
+	return writePalmDB(w, palmName(b.fb.GetTitle()), records)
+}
+
+// mobiImages returns image records and record indexes of the binaries relative to the first image.
+// Images other than JPEG and GIF are converted to JPEG, unreadable ones are skipped
+func (b *book) mobiImages() ([][]byte, map[*binary]int) {
+	images := [][]byte{}
+	recindex := map[*binary]int{}
+	for _, bin := range b.binaries {
+		data := bin.data
+		if bin.mediaType != "image/jpeg" && bin.mediaType != "image/jpg" && bin.mediaType != "image/gif" {
+			img, _, err := image.Decode(bytes.NewReader(bin.data))
+			if err != nil {
+				continue
+			}
+			buf := &bytes.Buffer{}
+			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 85}); err != nil {
+				continue
+			}
+			data = buf.Bytes()
+		}
+		images = append(images, data)
+		recindex[bin] = len(images)
+	}
+	return images, recindex
+}
+
+// mobiHTML returns book text in Mobipocket markup with links and images to be resolved by mobiLinks
+func (b *book) mobiHTML(recindex map[*binary]int) string {
+	seq := 0
+	toc := b.toc(b.mainBody(), "", 2, &seq)
+	rr := &renderer{
+		b: b,
+		link: func(id string) string {
+			if _, ok := b.files[id]; ok {
+				return "#" + sanitizeID(id)
+			}
+			return ""
+		},
+		imageSrc: func(bin *binary) string {
+			return fmt.Sprintf("recindex:%05d", recindex[bin])
+		},
+	}
+	rr.write("<html><head><title>", esc(b.fb.GetTitle()), "</title><guide>")
+	if len(toc) > 0 {
+		rr.write(`<reference type="toc" title="Table of Contents" href="#mobi-toc"/>`)
+	}
+	rr.write(`<reference type="text" title="Start" href="#mobi-start"/></guide></head><body>` + "\n")
+	authors := []string{}
+	for _, a := range b.fb.GetAuthors() {
+		authors = append(authors, a.Name)
+	}
+	if len(authors) > 0 {
+		rr.write(`<p align="center">`, esc(strings.Join(authors, ", ")), "</p>\n")
+	}
+	rr.write(`<h1 align="center">`, esc(b.fb.GetTitle()), "</h1>\n")
+	if n := b.doc.Path("description", "title-info", "annotation"); n != nil {
+		rr.block(n, 1)
+	}
+	if len(toc) > 0 {
+		rr.write(`<mbp:pagebreak/><div id="mobi-toc"><h2>Contents</h2>` + "\n")
+		tocList(&rr.sb, toc)
+		rr.write("</div>\n")
+	}
+	rr.write(`<mbp:pagebreak/><div id="mobi-start"></div>` + "\n")
+	first := true
+	for _, n := range b.mainBody().Children {
+		if n.Name == "section" {
+			if !first {
+				rr.write("<mbp:pagebreak/>\n")
+			}
+			first = false
+		}
+		rr.block(n, 0)
+	}
+	if notes := b.notesBodies(); len(notes) > 0 {
+		rr.write("<mbp:pagebreak/>\n")
+		rr.notes(notes)
+	}
+	rr.write("</body></html>\n")
+	return rr.String()
+}
+
+var (
+	rxMobiHref    = regexp.MustCompile(`href="#([^"]*)"`)
+	rxMobiID      = regexp.MustCompile(`<[a-zA-Z][^<>]*\sid="([^"]+)"`)
+	rxMobiImage   = regexp.MustCompile(`src="recindex:(\d+)"`)
+	rxMobiSection = regexp.MustCompile(`<(/?)section\b`)
+)
+
+// mobiLinks replaces internal links with file positions of the targets and image sources with record indexes
+func mobiLinks(s string) string {
+	s = rxMobiSection.ReplaceAllString(s, "<${1}div")
+	s = rxMobiImage.ReplaceAllString(s, `recindex="$1"`)
+	// Links are replaced with fixed width placeholders first, so the positions of targets do not move
+	var sb strings.Builder
+	type link struct {
+		pos int
+		id  string
+	}
+	links := []link{}
+	last := 0
+	for _, m := range rxMobiHref.FindAllStringSubmatchIndex(s, -1) {
+		sb.WriteString(s[last:m[0]])
+		sb.WriteString("filepos=")
+		links = append(links, link{sb.Len(), s[m[2]:m[3]]})
+		sb.WriteString("0000000000")
+		last = m[1]
+	}
+	sb.WriteString(s[last:])
+	text := []byte(sb.String())
+	targets := map[string]int{}
+	for _, m := range rxMobiID.FindAllSubmatchIndex(text, -1) {
+		id := string(text[m[2]:m[3]])
+		if _, ok := targets[id]; !ok {
+			targets[id] = m[0]
+		}
+	}
+	for _, l := range links {
+		copy(text[l.pos:], fmt.Sprintf("%010d", targets[l.id]))
+	}
+	return string(text)
+}
+
+// mobiHeader returns record 0 with PalmDOC, MOBI and EXTH headers
+func (c *Converter) mobiHeader(b *book, textLength, textRecords int, firstImage uint32, flis int, recindex map[*binary]int) []byte {
+	exth := c.exth(b, recindex)
+	name := []byte(b.fb.GetTitle())
+	h := &bytes.Buffer{}
+	// PalmDOC header, text is not compressed
+	put16(h, 1, 0)
+	put32(h, uint32(textLength))
+	put16(h, uint16(textRecords), mobiRecordSize, 0, 0)
+	// MOBI header
+	h.WriteString("MOBI")
+	put32(h, mobiHeaderLength, 2, 65001, crc32.ChecksumIEEE([]byte(b.identifier())), 6)
+	for i := 0; i < 10; i++ {
+		put32(h, mobiNone) // indexes
+	}
+	put32(h, uint32(textRecords+1), uint32(16+mobiHeaderLength+len(exth)), uint32(len(name)))
+	put32(h, mobiLocale(b.lang), 0, 0, 6, firstImage)
+	put32(h, 0, 0, 0, 0) // huffman
+	put32(h, 0x40)       // EXTH flags
+	h.Write(make([]byte, 32))
+	put32(h, mobiNone)
+	put32(h, mobiNone, 0, 0, 0) // DRM offset, count, size and flags
+	h.Write(make([]byte, 8))
+	put16(h, 1, uint16(flis-1))
+	put32(h, 1, uint32(flis+1), 1, uint32(flis), 1)
+	h.Write(make([]byte, 8))
+	put32(h, mobiNone, 0, mobiNone, mobiNone)
+	put32(h, 1)        // extra record data flags: multibyte characters
+	put32(h, mobiNone) // INDX record
+	h.Write(exth)
+	h.Write(name)
+	h.Write([]byte{0, 0})
+	for h.Len()%4 != 0 {
+		h.WriteByte(0)
+	}
+	return h.Bytes()
+}
+
+// exth returns EXTH header with the book metadata
+func (c *Converter) exth(b *book, recindex map[*binary]int) []byte {
+	records := &bytes.Buffer{}
+	count := 0
+	add := func(typ uint32, data []byte) {
+		put32(records, typ, uint32(8+len(data)))
+		records.Write(data)
+		count++
+	}
+	addString := func(typ uint32, s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			add(typ, []byte(s))
+		}
+	}
+	for _, a := range b.fb.GetAuthors() {
+		addString(100, a.Name)
+	}
+	pi := b.desc.PublishInfo
+	addString(101, pi.Publisher)
+	addString(103, b.annotation())
+	addString(104, pi.ISBN)
+	for _, g := range b.fb.GetGenres() {
+		g = strings.TrimSpace(g)
+		if c.GenreName != nil {
+			if name := c.GenreName(g); name != "" {
+				g = name
+			}
+		}
+		addString(105, g)
+	}
+	year := strings.TrimSpace(pi.Year)
+	if !rxYear.MatchString(year) {
+		year = strings.TrimSpace(b.fb.GetYear())
+	}
+	if rxYear.MatchString(year) {
+		addString(106, year)
+	}
+	addString(113, b.identifier())
+	addString(501, "EBOK")
+	addString(503, b.fb.GetTitle())
+	addString(524, b.lang)
+	if cover := b.cover(); cover != nil {
+		if i, ok := recindex[cover]; ok {
+			offset := &bytes.Buffer{}
+			put32(offset, uint32(i-1))
+			add(201, offset.Bytes())
+			add(202, offset.Bytes())
+		}
+	}
+	h := &bytes.Buffer{}
+	h.WriteString("EXTH")
+	put32(h, uint32(12+records.Len()), uint32(count))
+	h.Write(records.Bytes())
+	for h.Len()%4 != 0 {
+		h.WriteByte(0)
+	}
+	return h.Bytes()
+}
+
+func mobiFLIS() []byte {
+	h := &bytes.Buffer{}
+	h.WriteString("FLIS")
+	put32(h, 8)
+	put16(h, 65, 0)
+	put32(h, 0, mobiNone)
+	put16(h, 1, 3)
+	put32(h, 3, 1, mobiNone)
+	return h.Bytes()
+}
+
+func mobiFCIS(textLength int) []byte {
+	h := &bytes.Buffer{}
+	h.WriteString("FCIS")
+	put32(h, 20, 16, 1, 0, uint32(textLength), 0, 32, 8)
+	put16(h, 1, 1)
+	put32(h, 0)
+	return h.Bytes()
+}
+
+// writePalmDB writes Palm database of the records
+func writePalmDB(w io.Writer, name string, records [][]byte) error {
+	h := &bytes.Buffer{}
+	n := make([]byte, 32)
+	copy(n, name)
+	h.Write(n)
+	put16(h, 0, 0)
+	now := uint32(time.Now().Unix())
+	put32(h, now, now, 0, 0, 0, 0)
+	h.WriteString("BOOKMOBI")
+	put32(h, uint32(2*len(records)-1), 0)
+	put16(h, uint16(len(records)))
+	offset := h.Len() + 8*len(records) + 2
+	for i, rec := range records {
+		put32(h, uint32(offset))
+		// Record attributes byte is zero followed by 3 byte unique id
+		put32(h, uint32(2*i))
+		offset += len(rec)
+	}
+	put16(h, 0)
+	for _, rec := range records {
+		h.Write(rec)
+	}
+	_, err := h.WriteTo(w)
+	return err
+}
+
+// palmName returns Palm database name of the title
+func palmName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && (r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, fb2.CollapseSpaces(title))
+	if len(name) > 31 {
+		name = name[:31]
+	}
+	return name
+}
+
+// mobiLocale returns Windows language id of the language code
+func mobiLocale(lang string) uint32 {
+	switch lang {
+	case "en":
+		return 9
+	case "de":
+		return 7
+	case "es":
+		return 10
+	case "fr":
+		return 12
+	case "it":
+		return 16
+	case "pl":
+		return 21
+	case "ru":
+		return 25
+	case "uk":
+		return 34
+	case "be":
+		return 35
+	}
+	return 0
+}
+
+func put16(b *bytes.Buffer, v ...uint16) {
+	for _, x := range v {
+		b.Write([]byte{byte(x >> 8), byte(x)})
+	}
+}
+
+func put32(b *bytes.Buffer, v ...uint32) {
+	for _, x := range v {
+		b.Write([]byte{byte(x >> 24), byte(x >> 16), byte(x >> 8), byte(x)})
+	}
+}
@@ -0,0 +1,158 @@
+package convert
+
+import (
+	"io"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+)
+
+// TXTType is plain text media type
+const TXTType = "text/plain; charset=utf-8"
+
+// textIndent indents epigraphs, citations and poems
+const textIndent = "    "
+
+// TXT converts FB2 book read from r to UTF-8 plain text written to w.
+// Note references are kept in brackets and the notes follow the book text
+func (c *Converter) TXT(r io.Reader, w io.Writer) error {
+	b, err := readBook(r)
+	if err != nil {
+		return err
+	}
+	b.markNotes()
+	tr := &textRenderer{b: b}
+	authors := []string{}
+	for _, a := range b.fb.GetAuthors() {
+		authors = append(authors, a.Name)
+	}
+	if len(authors) > 0 {
+		tr.line("", strings.Join(authors, ", "))
+	}
+	tr.line("", b.fb.GetTitle())
+	tr.blank()
+	tr.blocks(b.mainBody(), "")
+	for _, body := range b.notesBodies() {
+		tr.blank()
+		for _, n := range body.Children {
+			if n.Name != "section" {
+				tr.block(n, "")
+				continue
+			}
+			// Note is written as its number followed by the note text
+			prefix := ""
+			if t := n.Child("title"); t != nil {
+				prefix = t.Content() + " "
+			}
+			for _, c := range n.Children {
+				if c.Name == "title" {
+					continue
+				}
+				if prefix != "" && c.Name == "p" {
+					tr.line("", prefix+tr.inline(c))
+					prefix = ""
+					continue
+				}
+				tr.block(c, textIndent)
+			}
+			if prefix != "" {
+				tr.line("", prefix)
+			}
+		}
+	}
+	_, err = io.WriteString(w, strings.TrimLeft(tr.sb.String(), "\n"))
+	return err
+}
+
+// textRenderer renders FB2 body elements as plain text lines
+type textRenderer struct {
+	b  *book
+	sb strings.Builder
+}
+
+func (tr *textRenderer) line(indent, s string) {
+	if s = strings.TrimSpace(s); s != "" {
+		tr.sb.WriteString(indent + s + "\n")
+	}
+}
+
+// blank writes empty line unless the text already ends with one
+func (tr *textRenderer) blank() {
+	if !strings.HasSuffix(tr.sb.String(), "\n\n") {
+		tr.sb.WriteString("\n")
+	}
+}
+
+func (tr *textRenderer) blocks(n *fb2.Node, indent string) {
+	for _, c := range n.Children {
+		tr.block(c, indent)
+	}
+}
+
+func (tr *textRenderer) block(n *fb2.Node, indent string) {
+	switch n.Name {
+	case "":
+		tr.line(indent, fb2.CollapseSpaces(n.Text))
+	case "title":
+		tr.blank()
+		for _, p := range n.ChildrenNamed("p") {
+			tr.line(indent, tr.inline(p))
+		}
+		tr.blank()
+	case "subtitle", "stanza":
+		tr.blank()
+		if n.Name == "subtitle" {
+			tr.line(indent, tr.inline(n))
+		} else {
+			tr.blocks(n, indent)
+		}
+		tr.blank()
+	case "p", "v", "date":
+		tr.line(indent, tr.inline(n))
+	case "text-author":
+		tr.line(indent+textIndent, tr.inline(n))
+	case "empty-line":
+		tr.blank()
+	case "epigraph", "cite", "poem":
+		tr.blank()
+		tr.blocks(n, indent+textIndent)
+		tr.blank()
+	case "section":
+		tr.blank()
+		tr.blocks(n, indent)
+	case "table":
+		for _, row := range n.ChildrenNamed("tr") {
+			cells := []string{}
+			for _, td := range row.Children {
+				if td.Name == "th" || td.Name == "td" {
+					cells = append(cells, tr.inline(td))
+				}
+			}
+			tr.line(indent, strings.Join(cells, "\t"))
+		}
+	case "image", "binary":
+	default:
+		tr.blocks(n, indent)
+	}
+}
+
+// inline returns the node text with note references in brackets
+func (tr *textRenderer) inline(n *fb2.Node) string {
+	var sb strings.Builder
+	var walk func(n *fb2.Node)
+	walk = func(n *fb2.Node) {
+		if n.IsText() {
+			sb.WriteString(n.Text)
+			return
+		}
+		if n.Name == "a" && (n.AttrValue("type") == "note" || tr.b.notes[strings.TrimPrefix(n.AttrValue("href"), "#")]) {
+			sb.WriteString("[" + strings.Trim(n.Content(), "[]{}") + "]")
+			return
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(fb2.CollapseSpaces(sb.String()))
+}
@@ -0,0 +1,334 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+)
+
+// renderer renders FB2 body elements as XHTML
+type renderer struct {
+	b  *book
+	sb strings.Builder
+	// link returns href of the element id
+	link func(id string) string
+	// imageSrc returns src of the image binary
+	imageSrc func(bin *binary) string
+	// epub enables EPUB 3 note references
+	epub bool
+}
+
+func (rr *renderer) String() string {
+	return rr.sb.String()
+}
+
+func (rr *renderer) write(s ...string) {
+	for _, v := range s {
+		rr.sb.WriteString(v)
+	}
+}
+
+// idAttr returns id attribute of the node or empty string
+func idAttr(n *fb2.Node) string {
+	if id := n.AttrValue("id"); id != "" {
+		return ` id="` + esc(sanitizeID(id)) + `"`
+	}
+	return ""
+}
+
+// block renders block element of the section of the depth
+func (rr *renderer) block(n *fb2.Node, depth int) {
+	switch n.Name {
+	case "":
+		if strings.TrimSpace(n.Text) != "" {
+			rr.write("<p>", esc(n.Text), "</p>")
+		}
+	case "section":
+		rr.write("<section", idAttr(n), ">\n")
+		rr.blocks(n, depth+1)
+		rr.write("</section>\n")
+	case "title":
+		rr.title(n, depth)
+	case "subtitle":
+		rr.write(`<p class="subtitle"`, idAttr(n), ">")
+		rr.inlines(n)
+		rr.write("</p>\n")
+	case "p":
+		rr.write("<p", idAttr(n), ">")
+		rr.inlines(n)
+		rr.write("</p>\n")
+	case "empty-line":
+		rr.write(`<p class="empty-line">&#160;</p>` + "\n")
+	case "epigraph":
+		rr.write(`<blockquote class="epigraph"`, idAttr(n), ">\n")
+		rr.blocks(n, depth)
+		rr.write("</blockquote>\n")
+	case "cite":
+		rr.write(`<blockquote class="cite"`, idAttr(n), ">\n")
+		rr.blocks(n, depth)
+		rr.write("</blockquote>\n")
+	case "annotation":
+		rr.write(`<div class="annotation"`, idAttr(n), ">\n")
+		rr.blocks(n, depth)
+		rr.write("</div>\n")
+	case "poem":
+		rr.write(`<div class="poem"`, idAttr(n), ">\n")
+		rr.blocks(n, depth)
+		rr.write("</div>\n")
+	case "stanza":
+		rr.write(`<div class="stanza">` + "\n")
+		rr.blocks(n, depth)
+		rr.write("</div>\n")
+	case "v":
+		rr.write(`<p class="v"`, idAttr(n), ">")
+		rr.inlines(n)
+		rr.write("</p>\n")
+	case "text-author":
+		rr.write(`<p class="text-author"`, idAttr(n), ">")
+		rr.inlines(n)
+		rr.write("</p>\n")
+	case "date":
+		rr.write(`<p class="date">`)
+		rr.inlines(n)
+		rr.write("</p>\n")
+	case "image":
+		rr.write(`<div class="image"`, idAttr(n), ">")
+		rr.image(n)
+		rr.write("</div>\n")
+	case "table":
+		rr.write(`<table`, idAttr(n), ">\n")
+		for _, tr := range n.ChildrenNamed("tr") {
+			rr.write("<tr>")
+			for _, td := range tr.Children {
+				if td.Name != "th" && td.Name != "td" {
+					continue
+				}
+				rr.write("<", td.Name)
+				for _, a := range []string{"colspan", "rowspan", "align", "valign"} {
+					if v := td.AttrValue(a); v != "" {
+						rr.write(" ", a, `="`, esc(v), `"`)
+					}
+				}
+				rr.write(">")
+				rr.inlines(td)
+				rr.write("</", td.Name, ">")
+			}
+			rr.write("</tr>\n")
+		}
+		rr.write("</table>\n")
+	default:
+		rr.blocks(n, depth)
+	}
+}
+
+func (rr *renderer) blocks(n *fb2.Node, depth int) {
+	for _, c := range n.Children {
+		rr.block(c, depth)
+	}
+}
+
+// title renders section title as heading of the section depth
+func (rr *renderer) title(n *fb2.Node, depth int) {
+	level := depth + 1
+	if level > 6 {
+		level = 6
+	}
+	h := fmt.Sprint("h", level)
+	rr.write("<", h, idAttr(n), ">")
+	first := true
+	for _, p := range n.Children {
+		if p.Name != "p" {
+			continue
+		}
+		if !first {
+			rr.write("<br/>")
+		}
+		rr.inlines(p)
+		first = false
+	}
+	rr.write("</", h, ">\n")
+}
+
+var inlineTags = map[string]string{
+	"strong":        "strong",
+	"emphasis":      "em",
+	"strikethrough": "del",
+	"sub":           "sub",
+	"sup":           "sup",
+	"code":          "code",
+	"style":         "span",
+}
+
+// inlines renders the node content as inline text
+func (rr *renderer) inlines(n *fb2.Node) {
+	for _, c := range n.Children {
+		rr.inline(c)
+	}
+}
+
+func (rr *renderer) inline(n *fb2.Node) {
+	if n.IsText() {
+		rr.write(esc(n.Text))
+		return
+	}
+	if tag, ok := inlineTags[n.Name]; ok {
+		rr.write("<", tag, ">")
+		rr.inlines(n)
+		rr.write("</", tag, ">")
+		return
+	}
+	switch n.Name {
+	case "a":
+		rr.anchor(n)
+	case "image":
+		rr.image(n)
+	default:
+		rr.inlines(n)
+	}
+}
+
+func (rr *renderer) anchor(n *fb2.Node) {
+	href := n.AttrValue("href")
+	if !strings.HasPrefix(href, "#") {
+		if href == "" {
+			rr.inlines(n)
+			return
+		}
+		rr.write(`<a href="`, esc(href), `">`)
+		rr.inlines(n)
+		rr.write("</a>")
+		return
+	}
+	id := strings.TrimPrefix(href, "#")
+	link := rr.link(id)
+	if link == "" {
+		rr.inlines(n)
+		return
+	}
+	if rr.b.notes[id] || n.AttrValue("type") == "note" {
+		if rr.epub {
+			rr.write(`<a epub:type="noteref" class="noteref" href="`, esc(link), `">`)
+		} else {
+			rr.write(`<a class="noteref" href="`, esc(link), `">`)
+		}
+		// Note references are usually given in brackets, superscript is enough
+		rr.write("<sup>", esc(strings.Trim(n.Content(), "[]{}")), "</sup></a>")
+		return
+	}
+	rr.write(`<a href="`, esc(link), `">`)
+	rr.inlines(n)
+	rr.write("</a>")
+}
+
+func (rr *renderer) image(n *fb2.Node) {
+	bin := rr.b.images[strings.TrimPrefix(n.AttrValue("href"), "#")]
+	if bin == nil {
+		return
+	}
+	rr.write(`<img src="`, esc(rr.imageSrc(bin)), `" alt="`, esc(n.AttrValue("alt")), `"/>`)
+}
+
+// notes renders the notes bodies, every note section is marked as footnote for popups
+func (rr *renderer) notes(bodies []*fb2.Node) {
+	for _, body := range bodies {
+		rr.write("<section", idAttr(body), ">\n")
+		for _, c := range body.Children {
+			switch {
+			case c.Name == "title":
+				rr.title(c, 0)
+			case c.Name == "section" && c.AttrValue("id") != "":
+				if rr.epub {
+					rr.write(`<aside epub:type="footnote" class="note"`, idAttr(c), ">\n")
+				} else {
+					rr.write(`<div class="note"`, idAttr(c), ">\n")
+				}
+				for _, nc := range c.Children {
+					if nc.Name == "title" {
+						rr.write(`<p class="note-title">`, esc(nc.Content()), "</p>\n")
+						continue
+					}
+					rr.block(nc, 2)
+				}
+				if rr.epub {
+					rr.write("</aside>\n")
+				} else {
+					rr.write("</div>\n")
+				}
+			default:
+				rr.block(c, 1)
+			}
+		}
+		rr.write("</section>\n")
+	}
+}
+
+// markNotes records the note ids of the notes bodies
+func (b *book) markNotes() {
+	for _, body := range b.notesBodies() {
+		for _, s := range body.ChildrenNamed("section") {
+			if id := s.AttrValue("id"); id != "" {
+				b.notes[id] = true
+			}
+		}
+	}
+}
+
+// tocItem is table of contents item
+type tocItem struct {
+	title    string
+	href     string
+	children []*tocItem
+}
+
+// toc returns contents items of the titled sections of the node up to the depth.
+// Sections without id get generated ones to be linked to
+func (b *book) toc(n *fb2.Node, file string, depth int, seq *int) []*tocItem {
+	items := []*tocItem{}
+	if depth == 0 {
+		return items
+	}
+	for _, s := range n.ChildrenNamed("section") {
+		title := ""
+		if t := s.Child("title"); t != nil {
+			title = t.Content()
+		}
+		children := b.toc(s, file, depth-1, seq)
+		if title == "" {
+			items = append(items, children...)
+			continue
+		}
+		if s.AttrValue("id") == "" {
+			*seq++
+			s.Attr = append(s.Attr, xmlAttr("id", fmt.Sprint("toc-", *seq)))
+		}
+		items = append(items, &tocItem{
+			title:    title,
+			href:     file + "#" + sanitizeID(s.AttrValue("id")),
+			children: children,
+		})
+	}
+	return items
+}
+
+// stylesheet is shared by converted books
+const stylesheet = `body { margin: 0 2%; line-height: 1.4; }
+h1, h2, h3, h4, h5, h6 { text-align: center; page-break-after: avoid; }
+p { margin: 0; text-indent: 1.5em; text-align: justify; }
+p.subtitle { text-align: center; font-weight: bold; text-indent: 0; margin: 1em 0; }
+p.empty-line { text-indent: 0; }
+blockquote { margin: 1em 0 1em 20%; }
+blockquote.cite { margin: 1em 5%; }
+p.text-author { text-align: right; font-style: italic; }
+p.date { text-align: right; font-size: 0.9em; }
+div.poem { margin: 1em 0 1em 10%; }
+div.stanza { margin: 0.5em 0; }
+p.v { text-indent: 0; text-align: left; }
+div.image, div.cover { text-align: center; margin: 1em 0; }
+div.image img { max-width: 100%; }
+div.cover img { max-width: 100%; max-height: 100%; }
+a.noteref { text-decoration: none; }
+p.note-title { font-weight: bold; text-indent: 0; }
+table { border-collapse: collapse; margin: 1em auto; }
+td, th { border: 1px solid #888; padding: 0.2em 0.4em; }
+`
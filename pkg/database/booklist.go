@@ -0,0 +1,369 @@
+package database
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/model"
+	"github.com/vinser/flibgo/pkg/search"
+)
+
+// BookList is a list of books ordered by the sort key and book id. It is paged with
+// keyset cursors, so pages don't shift when books are added while paging
+type BookList struct {
+	db      *DB
+	from    string // FROM and WHERE clauses with books table aliased as "b"
+	args    []interface{}
+	order   string // sort key expression
+	desc    bool   // descending order
+	filters []filter
+}
+
+// filter is a book list facet condition
+type filter struct {
+	facet string
+	where string
+	arg   interface{}
+}
+
+// Book list orders
+const (
+	OrderTitle = "title"
+	OrderYear  = "year"
+	OrderAdded = "added" // newest first
+	OrderSerie = "serie" // by number in serie
+)
+
+// Book list facets
+const (
+	FacetLanguage = "language"
+	FacetFormat   = "format"
+)
+
+// Facet is a value of the book list facet and number of books having it
+type Facet struct {
+	Value string
+	Title string
+	Count int64
+}
+
+// Cursor points to the last book of a page
+type Cursor struct {
+	Sort string
+	ID   int64
+	// Position of the book in the list from 1 and the list length, so the pages following
+	// the first one don't count the books again. Zero when unknown
+	Pos   int64
+	Total int64
+}
+
+// Token returns opaque cursor token to be used in page links
+func (c *Cursor) Token() string {
+	id := fmt.Sprint(c.ID)
+	if c.Pos > 0 {
+		id = fmt.Sprint(c.ID, ".", c.Pos, ".", c.Total)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(id + "|" + c.Sort))
+}
+
+// ParseCursor returns cursor of the token or nil if the token is empty or malformed
+func ParseCursor(token string) *Cursor {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	id, sort, found := strings.Cut(string(b), "|")
+	if !found {
+		return nil
+	}
+	id, position, _ := strings.Cut(id, ".")
+	c := &Cursor{Sort: sort}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil
+	}
+	// Malformed position is treated as unknown one
+	pos, total, _ := strings.Cut(position, ".")
+	c.Pos, _ = strconv.ParseInt(pos, 10, 64)
+	c.Total, _ = strconv.ParseInt(total, 10, 64)
+	if c.Pos <= 0 || c.Total < c.Pos {
+		c.Pos, c.Total = 0, 0
+	}
+	return c
+}
+
+func (db *DB) AuthorBookList(authorId, serieId int64) *BookList {
+	if serieId == 0 {
+		return &BookList{
+			db:    db,
+			from:  `FROM books as b WHERE EXISTS (SELECT 1 FROM books_authors as ba WHERE ba.book_id=b.id AND ba.author_id=?)`,
+			args:  []interface{}{authorId},
+			order: "b.sort",
+		}
+	}
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b, books_series as bs WHERE bs.book_id=b.id AND bs.serie_id=? AND EXISTS (SELECT 1 FROM books_authors as ba WHERE ba.book_id=b.id AND ba.author_id=?)`,
+		args:  []interface{}{serieId, authorId},
+		order: "bs.serie_num",
+	}
+}
+
+func (db *DB) GenreBookList(genreCode string) *BookList {
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b WHERE EXISTS (SELECT 1 FROM books_genres as bg WHERE bg.book_id=b.id AND bg.genre_code=?)`,
+		args:  []interface{}{genreCode},
+		order: "b.sort",
+	}
+}
+
+func (db *DB) SerieBookList(serieId int64) *BookList {
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b, books_series as bs WHERE bs.book_id=b.id AND bs.serie_id=?`,
+		args:  []interface{}{serieId},
+		order: "bs.serie_num",
+	}
+}
+
+func (db *DB) QueryBookList(sq *search.Query) *BookList {
+	where, args := searchQueryCondition(sq)
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b WHERE ` + where,
+		args:  args,
+		order: "b.sort",
+	}
+}
+
+// OrderedBy returns the book list order
+func (bl *BookList) OrderedBy() string {
+	switch bl.order {
+	case "bs.serie_num":
+		return OrderSerie
+	case "b.year":
+		return OrderYear
+	case "b.updated":
+		return OrderAdded
+	}
+	return OrderTitle
+}
+
+// OrderBy sets the book list order. Unknown orders leave the list order as is
+func (bl *BookList) OrderBy(order string) *BookList {
+	switch order {
+	case OrderTitle:
+		bl.order, bl.desc = "b.sort", false
+	case OrderYear:
+		bl.order, bl.desc = "b.year", false
+	case OrderAdded:
+		bl.order, bl.desc = "b.updated", true
+	case OrderSerie:
+		if strings.Contains(bl.from, "books_series as bs") {
+			bl.order, bl.desc = "bs.serie_num", false
+		}
+	}
+	return bl
+}
+
+// Filter narrows the book list down to the books of the facet value. Empty value doesn't filter
+func (bl *BookList) Filter(facet, value string) *BookList {
+	if value == "" {
+		return bl
+	}
+	switch facet {
+	case FacetLanguage:
+		bl.filters = append(bl.filters, filter{facet, bookLanguageCondition, value})
+	case FacetFormat:
+		bl.filters = append(bl.filters, filter{facet, `b.format=?`, value})
+	}
+	return bl
+}
+
+// Facets returns the facet values of the list books with book counts. Filters of other
+// facets are applied, so the counts are the numbers of books the facet links lead to
+func (bl *BookList) Facets(facet string) []*Facet {
+	facets := []*Facet{}
+	var q string
+	switch facet {
+	case FacetLanguage:
+		q = `SELECT l.code, COALESCE(NULLIF(l.name, ''), l.code), count(*) FROM languages as l, (SELECT b.language_id ` + bl.where(facet) + `) as fb WHERE l.id=fb.language_id GROUP BY l.code, l.name ORDER BY count(*) DESC`
+	case FacetFormat:
+		q = `SELECT fb.format, fb.format, count(*) FROM (SELECT b.format ` + bl.where(facet) + `) as fb GROUP BY fb.format ORDER BY count(*) DESC`
+	default:
+		return facets
+	}
+	rows, err := bl.db.Query(q, bl.whereArgs(facet)...)
+	if err != nil {
+		log.Println("DB facets query error: ", err.Error())
+		return facets
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f := &Facet{}
+		if err = rows.Scan(&f.Value, &f.Title, &f.Count); err != nil {
+			log.Fatal(err)
+		}
+		facets = append(facets, f)
+	}
+	return facets
+}
+
+// where returns FROM and WHERE clauses of the list with filters applied except the skipped facet one
+func (bl *BookList) where(skip string) string {
+	w := bl.from
+	for _, f := range bl.filters {
+		if f.facet != skip {
+			w += ` AND ` + f.where
+		}
+	}
+	return w
+}
+
+func (bl *BookList) whereArgs(skip string) []interface{} {
+	args := append([]interface{}{}, bl.args...)
+	for _, f := range bl.filters {
+		if f.facet != skip {
+			args = append(args, f.arg)
+		}
+	}
+	return args
+}
+
+// keyset returns condition of the books following the cursor in the list order,
+// or preceding it when backward is set
+func (bl *BookList) keyset(c *Cursor, backward bool) (string, []interface{}) {
+	op := ">"
+	if bl.desc != backward {
+		op = "<"
+	}
+	return ` AND (` + bl.order + op + `? OR (` + bl.order + `=? AND b.id` + op + `?))`, []interface{}{c.Sort, c.Sort, c.ID}
+}
+
+// orderBy returns ORDER BY clause of the list order, or of the reverse one when backward is set
+func (bl *BookList) orderBy(backward bool) string {
+	dir := ""
+	if bl.desc != backward {
+		dir = " DESC"
+	}
+	return ` ORDER BY ` + bl.order + dir + `, b.id` + dir
+}
+
+func (db *DB) LanguageBookList(code string) *BookList {
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b WHERE ` + bookLanguageCondition,
+		args:  []interface{}{code},
+		order: "b.sort",
+	}
+}
+
+// RecentBookList lists books added since the time, newest first
+func (db *DB) RecentBookList(since int64) *BookList {
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b WHERE b.updated>=?`,
+		args:  []interface{}{since},
+		order: "b.updated",
+		desc:  true,
+	}
+}
+
+// BatchBookList lists books added by the scan batch
+func (db *DB) BatchBookList(batch int64) *BookList {
+	return &BookList{
+		db:    db,
+		from:  `FROM books as b WHERE b.updated=?`,
+		args:  []interface{}{batch},
+		order: "b.sort",
+	}
+}
+
+// Page is a part of the book list
+type Page struct {
+	Books []*model.Book
+	First *Cursor // cursor of the first page book
+	Last  *Cursor // cursor of the last page book
+}
+
+// After returns the page of up to limit books following the cursor.
+// Page starts from the first book of the list when the cursor is nil
+func (bl *BookList) After(c *Cursor, limit int) *Page {
+	return bl.pageFrom(c, limit, false)
+}
+
+// Before returns the page of up to limit books preceding the cursor.
+// Page ends with the last book of the list when the cursor is nil
+func (bl *BookList) Before(c *Cursor, limit int) *Page {
+	return bl.pageFrom(c, limit, true)
+}
+
+func (bl *BookList) pageFrom(c *Cursor, limit int, backward bool) *Page {
+	q := `SELECT b.id, b.title, b.plot, b.cover, ` + bl.order + ` ` + bl.where("")
+	args := bl.whereArgs("")
+	if c != nil {
+		cond, condArgs := bl.keyset(c, backward)
+		q += cond
+		args = append(args, condArgs...)
+	}
+	q += bl.orderBy(backward) + ` LIMIT ?`
+	args = append(args, limit)
+	return bl.page(q, args, backward)
+}
+
+func (bl *BookList) page(q string, args []interface{}, reverse bool) *Page {
+	p := &Page{Books: []*model.Book{}}
+	rows, err := bl.db.Query(q, args...)
+	if err != nil {
+		log.Println("DB page query error: ", err.Error())
+		return p
+	}
+	defer rows.Close()
+	cursors := []*Cursor{}
+	for rows.Next() {
+		b := &model.Book{}
+		c := &Cursor{}
+		if err = rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover, &c.Sort); err != nil {
+			log.Fatal(err)
+		}
+		c.ID = b.ID
+		p.Books = append(p.Books, b)
+		cursors = append(cursors, c)
+	}
+	if len(p.Books) == 0 {
+		return p
+	}
+	if reverse {
+		for i, j := 0, len(p.Books)-1; i < j; i, j = i+1, j-1 {
+			p.Books[i], p.Books[j] = p.Books[j], p.Books[i]
+			cursors[i], cursors[j] = cursors[j], cursors[i]
+		}
+	}
+	p.First, p.Last = cursors[0], cursors[len(cursors)-1]
+	return p
+}
+
+// CountBefore returns number of books preceding the cursor
+func (bl *BookList) CountBefore(c *Cursor) int64 {
+	var n int64 = 0
+	cond, condArgs := bl.keyset(c, true)
+	q := `SELECT count(*) ` + bl.where("") + cond
+	args := append(bl.whereArgs(""), condArgs...)
+	if err := bl.db.QueryRow(q, args...).Scan(&n); err != nil {
+		return 0
+	}
+	return n
+}
+
+// Count returns total number of books in the list
+func (bl *BookList) Count() int64 {
+	var c int64 = 0
+	q := `SELECT count(*) ` + bl.where("")
+	if err := bl.db.QueryRow(q, bl.whereArgs("")...).Scan(&c); err != nil {
+		return 0
+	}
+	return c
+}
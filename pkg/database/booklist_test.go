@@ -0,0 +1,57 @@
+package database
+
+import "testing"
+
+func TestCursorToken(t *testing.T) {
+	var testArgs = []*Cursor{
+		{Sort: "ВОЙНА И МИР", ID: 42},
+		{Sort: "A|B", ID: 1},
+		{Sort: "", ID: 7},
+		{Sort: "1.5|2", ID: 9, Pos: 31, Total: 41},
+	}
+	for _, c := range testArgs {
+		got := ParseCursor(c.Token())
+		if got == nil || *got != *c {
+			t.Errorf("ParseCursor(%q): expecting %#v, got: %#v", c.Token(), c, got)
+		}
+	}
+	// Malformed position is unknown one
+	if got := ParseCursor("OS4zMS4yMHxzb3J0"); got == nil || got.ID != 9 || got.Pos != 0 || got.Total != 0 {
+		t.Errorf("ParseCursor of position past total: expecting unknown position, got: %#v", got)
+	}
+	for _, token := range []string{"", "!!!", "bm8tc2VwYXJhdG9y", "eHxzb3J0"} {
+		if got := ParseCursor(token); got != nil {
+			t.Errorf("ParseCursor(%q): expecting nil, got: %#v", token, got)
+		}
+	}
+}
+
+func TestBookListOrder(t *testing.T) {
+	c := &Cursor{Sort: "1700000000", ID: 5}
+	bl := (&DB{}).GenreBookList("sf").OrderBy(OrderAdded)
+	if got := bl.OrderedBy(); got != OrderAdded {
+		t.Errorf("OrderedBy: expecting %q, got: %q", OrderAdded, got)
+	}
+	if got, _ := bl.keyset(c, false); got != ` AND (b.updated<? OR (b.updated=? AND b.id<?))` {
+		t.Errorf("keyset forward: got %q", got)
+	}
+	if got, _ := bl.keyset(c, true); got != ` AND (b.updated>? OR (b.updated=? AND b.id>?))` {
+		t.Errorf("keyset backward: got %q", got)
+	}
+	if got := bl.orderBy(true); got != ` ORDER BY b.updated, b.id` {
+		t.Errorf("orderBy backward: got %q", got)
+	}
+	if got := bl.OrderBy(OrderSerie).OrderedBy(); got != OrderAdded {
+		t.Errorf("OrderBy(%q) of not serie list: expecting %q, got: %q", OrderSerie, OrderAdded, got)
+	}
+}
+
+func TestBookListFilter(t *testing.T) {
+	bl := (&DB{}).GenreBookList("sf").Filter(FacetLanguage, "ru").Filter(FacetFormat, "fb2").Filter(FacetFormat, "")
+	if got := len(bl.whereArgs("")); got != 3 {
+		t.Errorf("whereArgs: expecting 3 args, got: %d", got)
+	}
+	if got := bl.whereArgs(FacetLanguage); len(got) != 2 || got[1] != "fb2" {
+		t.Errorf("whereArgs(%q): expecting [sf fb2], got: %v", FacetLanguage, got)
+	}
+}
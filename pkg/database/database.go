@@ -10,8 +10,12 @@ import (
 	"unicode/utf8"
 
 	"github.com/vinser/flibgo/pkg/model"
+	"github.com/vinser/flibgo/pkg/normalize"
+	"github.com/vinser/flibgo/pkg/search"
 
 	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
 )
 
 type DB struct {
@@ -26,7 +30,7 @@ func (db *DB) NewBook(b *model.Book) int64 {
 	}
 	languageId := db.NewLanguage(b.Language)
 
-	q := "INSERT INTO books (file, crc32, archive, size, format, title, sort, year,language_id, plot, cover, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	q := "INSERT INTO books (file, crc32, archive, size, format, title, sort, search_key, year,language_id, plot, cover, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	res, err := db.Exec(q,
 		b.File,
 		b.CRC32,
@@ -35,6 +39,7 @@ func (db *DB) NewBook(b *model.Book) int64 {
 		b.Format,
 		b.Title,
 		b.Sort,
+		normalize.Key(b.Title),
 		b.Year,
 		languageId,
 		b.Plot,
@@ -91,15 +96,42 @@ func (db *DB) FindBook(b *model.Book) int64 {
 }
 
 func (db *DB) FindBookById(id int64) *model.Book {
-	b := &model.Book{}
-	q := "SELECT file, archive, format, title, cover FROM books WHERE id=?"
-	err := db.QueryRow(q, id).Scan(&b.File, &b.Archive, &b.Format, &b.Title, &b.Cover)
+	b := &model.Book{ID: id}
+	q := "SELECT file, crc32, archive, size, format, title, plot, cover, updated FROM books WHERE id=?"
+	err := db.QueryRow(q, id).Scan(&b.File, &b.CRC32, &b.Archive, &b.Size, &b.Format, &b.Title, &b.Plot, &b.Cover, &b.Updated)
 	if err == sql.ErrNoRows {
 		return nil
 	}
 	return b
 }
 
+// FillBook loads book details to be shown in catalog entries: year, language, size,
+// authors, genres and serie
+func (db *DB) FillBook(b *model.Book) {
+	b.Language = &model.Language{}
+	q := "SELECT b.file, b.crc32, b.archive, b.size, b.format, b.year, b.updated, l.code, l.name FROM books as b, languages as l WHERE b.id=? AND l.id=b.language_id"
+	err := db.QueryRow(q, b.ID).Scan(&b.File, &b.CRC32, &b.Archive, &b.Size, &b.Format, &b.Year, &b.Updated, &b.Language.Code, &b.Language.Name)
+	if err != nil {
+		log.Println(err)
+	}
+	b.Authors = db.AuthorsByBookId(b.ID)
+	b.Genres = []string{}
+	q = "SELECT DISTINCT genre_code FROM books_genres WHERE book_id=?"
+	rows, err := db.Query(q, b.ID)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var g string
+			if err := rows.Scan(&g); err == nil {
+				b.Genres = append(b.Genres, g)
+			}
+		}
+	}
+	b.Serie = &model.Serie{}
+	q = "SELECT s.id, s.name, bs.serie_num FROM series as s, books_series as bs WHERE bs.book_id=? AND s.id=bs.serie_id"
+	db.QueryRow(q, b.ID).Scan(&b.Serie.ID, &b.Serie.Name, &b.SerieNum)
+}
+
 func (db *DB) IsFileInStock(file string, crc32 uint32) bool {
 	var id int64
 	q := "SELECT id FROM books WHERE file=? AND crc32=?"
@@ -120,12 +152,69 @@ func (db *DB) NewLanguage(l *model.Language) int64 {
 	if id != 0 {
 		return id
 	}
+	if l.Name == "" {
+		l.Name = Endonym(l.Code)
+	}
 	q := "INSERT INTO languages (code, name) VALUES (?, ?)"
-	res, _ := db.Exec(q, l.Code, l.Code)
+	res, _ := db.Exec(q, l.Code, l.Name)
 	id, _ = res.LastInsertId()
 	return id
 }
 
+// Endonym returns the language name in the language itself or the code for unknown languages
+func Endonym(code string) string {
+	if name := display.Self.Name(language.Make(code)); name != "" {
+		return name
+	}
+	return code
+}
+
+// UpdateLanguageNames replaces language names left equal to codes with endonyms
+func (db *DB) UpdateLanguageNames() {
+	languages := []*model.Language{}
+	rows, err := db.Query("SELECT id, code FROM languages WHERE name IS NULL OR name=code")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &model.Language{}
+		if err := rows.Scan(&l.ID, &l.Code); err != nil {
+			log.Fatal(err)
+		}
+		languages = append(languages, l)
+	}
+	for _, l := range languages {
+		if _, err := db.Exec("UPDATE languages SET name=? WHERE id=?", Endonym(l.Code), l.ID); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// ListLanguages returns languages of the books with book counts
+func (db *DB) ListLanguages() []*model.Language {
+	languages := []*model.Language{}
+	q := "SELECT l.id, l.code, COALESCE(l.name, l.code), count(*) FROM languages as l, books as b WHERE b.language_id=l.id GROUP BY l.id ORDER BY count(*) DESC"
+	rows, err := db.Query(q)
+	if err != nil {
+		log.Println(err)
+		return languages
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &model.Language{}
+		if err := rows.Scan(&l.ID, &l.Code, &l.Name, &l.Count); err != nil {
+			log.Fatal(err)
+		}
+		languages = append(languages, l)
+	}
+	return languages
+}
+
+// bookLanguageCondition restricts books aliased as b to the language
+const bookLanguageCondition = `b.language_id IN (SELECT id FROM languages WHERE code=?)`
+
 func (db *DB) FindLanguage(l *model.Language) int64 {
 	var id int64 = 0
 	q := "SELECT id FROM languages WHERE code LIKE ?"
@@ -138,17 +227,21 @@ func (db *DB) FindLanguage(l *model.Language) int64 {
 
 // Authors
 func (db *DB) NewAuthor(a *model.Author) int64 {
+	db.resolveAuthorAlias(a)
 	id := db.FindAuthor(a)
 	if id != 0 {
 		return id
 	}
-	q := "INSERT INTO authors (name, sort) VALUES (?, ?)"
-	res, _ := db.Exec(q, a.Name, a.Sort)
+	q := "INSERT INTO authors (name, sort, search_key) VALUES (?, ?, ?)"
+	res, _ := db.Exec(q, a.Name, a.Sort, normalize.Key(a.Sort))
 	id, _ = res.LastInsertId()
 	return id
 }
 
-func (db *DB) ListAuthors(prefix, language string) []*model.Author {
+// ListAuthors returns author name prefixes one letter longer than the prefix with author counts.
+// Prefixes are ordered by the alphabet of the language first. Authors are limited to the ones
+// having books in the book language when it is set
+func (db *DB) ListAuthors(prefix, language, bookLanguage string) []*model.Author {
 	var order1, order2 string
 	switch language {
 	case "ru":
@@ -158,17 +251,22 @@ func (db *DB) ListAuthors(prefix, language string) []*model.Author {
 		order1 = "a" // Latin 'a'
 		order2 = "а" // Cyrilic 'а'
 	}
+	where, args := "1=1", []interface{}{}
+	if bookLanguage != "" {
+		where = `EXISTS (SELECT 1 FROM books_authors as ba, books as b WHERE ba.author_id=authors.id AND b.id=ba.book_id AND ` + bookLanguageCondition + `)`
+		args = append(args, bookLanguage)
+	}
 	l := utf8.RuneCountInString(prefix) + 1
 	var (
 		rows *sql.Rows
 		err  error
 	)
 	if l == 1 {
-		q := fmt.Sprint(`SELECT id, name, substr(sort,1,1) as s, count(*) as c FROM authors GROUP BY s ORDER BY s<'`, order1, `', s<'`, order2, `',s`)
-		rows, err = db.Query(q)
+		q := fmt.Sprint(`SELECT id, name, substr(sort,1,1) as s, count(*) as c FROM authors WHERE `, where, ` GROUP BY s ORDER BY s<'`, order1, `', s<'`, order2, `',s`)
+		rows, err = db.Query(q, args...)
 	} else {
-		q := fmt.Sprint(`SELECT id, name, substr(sort,1,`, fmt.Sprint(l), `) as s, count(*) as c FROM authors WHERE sort LIKE ? GROUP BY s`)
-		rows, err = db.Query(q, prefix+"%")
+		q := fmt.Sprint(`SELECT id, name, substr(sort,1,`, fmt.Sprint(l), `) as s, count(*) as c FROM authors WHERE sort LIKE ? AND `, where, ` GROUP BY s`)
+		rows, err = db.Query(q, append([]interface{}{prefix + "%"}, args...)...)
 	}
 	if err != nil {
 		log.Fatal(err)
@@ -186,10 +284,17 @@ func (db *DB) ListAuthors(prefix, language string) []*model.Author {
 	return authors
 }
 
-func (db *DB) ListAuthorWithTotals(prefix string) []*model.Author {
+// ListAuthorWithTotals returns authors with the name prefix and their book counts.
+// Books are limited to the book language when it is set
+func (db *DB) ListAuthorWithTotals(prefix, bookLanguage string) []*model.Author {
 	authors := []*model.Author{}
 	q := `SELECT a.id, a.name, a.sort, count(*) FROM authors as a, books_authors as ba WHERE sort LIKE ? AND a.id=ba.author_id GROUP BY a.sort`
-	rows, err := db.Query(q, prefix+"%")
+	args := []interface{}{prefix + "%"}
+	if bookLanguage != "" {
+		q = `SELECT a.id, a.name, a.sort, count(*) FROM authors as a, books_authors as ba, books as b WHERE sort LIKE ? AND a.id=ba.author_id AND b.id=ba.book_id AND ` + bookLanguageCondition + ` GROUP BY a.sort`
+		args = append(args, bookLanguage)
+	}
+	rows, err := db.Query(q, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -205,35 +310,6 @@ func (db *DB) ListAuthorWithTotals(prefix string) []*model.Author {
 	return authors
 }
 
-func (db *DB) ListAuthorBooks(authorId, serieId int64, limit, offset int) []*model.Book {
-	var (
-		q    string
-		rows *sql.Rows
-		err  error
-	)
-	if serieId == 0 {
-		q = `SELECT b.id, b.title, b.plot, b.cover FROM books as b, books_authors as ba WHERE ba.author_id=? AND b.id=ba.book_id ORDER BY b.sort`
-		rows, err = db.pageQuery(q, limit, offset, authorId)
-	} else {
-		q = `SELECT b.id, b.title, b.plot, b.cover FROM books as b, books_authors as ba, series as s, books_series as bs WHERE ba.author_id=? AND ba.book_id=b.id AND bs.book_id=b.id AND bs.serie_id=? GROUP BY b.title`
-		rows, err = db.pageQuery(q, limit, offset, authorId, serieId)
-	}
-	if err != nil {
-		log.Println("DB page query error: ", err.Error())
-	}
-	defer rows.Close()
-	books := []*model.Book{}
-
-	for rows.Next() {
-		b := &model.Book{}
-		if err = rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover); err != nil {
-			log.Fatal(err)
-		}
-		books = append(books, b)
-	}
-	return books
-}
-
 func (db *DB) AuthorBookSeries(id int64) []*model.Serie {
 	series := []*model.Serie{}
 	q := `SELECT s.id, s.name FROM books_authors as ba, books as b, books_series as bs, series as s WHERE ba.author_id=? AND b.id=ba.book_id AND b.id=bs.book_id AND s.id=bs.serie_id GROUP BY s.name`
@@ -273,6 +349,72 @@ func (db *DB) FindAuthor(a *model.Author) int64 {
 	return id
 }
 
+// resolveAuthorAlias replaces author name and sort with canonical ones if the author is known alias
+func (db *DB) resolveAuthorAlias(a *model.Author) {
+	q := "SELECT name, sort FROM author_aliases WHERE alias=?"
+	db.QueryRow(q, a.Sort).Scan(&a.Name, &a.Sort)
+}
+
+// MergeAuthors re-points books of the author to the canonical one and deletes the author.
+// The author sort is kept as an alias of the canonical author to survive reindex
+func (db *DB) MergeAuthors(authorId, canonicalId int64) error {
+	if authorId == canonicalId {
+		return fmt.Errorf("author %d can't be merged with itself", authorId)
+	}
+	author := db.AuthorByID(authorId)
+	if author == nil {
+		return fmt.Errorf("author %d not found", authorId)
+	}
+	canonical := db.AuthorByID(canonicalId)
+	if canonical == nil {
+		return fmt.Errorf("canonical author %d not found", canonicalId)
+	}
+	stmts := []stmt{
+		{"UPDATE books_authors SET author_id=? WHERE author_id=?", []interface{}{canonicalId, authorId}},
+		// Books that had both authors would have the canonical one twice
+		{"DELETE ba1 FROM books_authors as ba1, books_authors as ba2 WHERE ba1.book_id=ba2.book_id AND ba1.author_id=ba2.author_id AND ba1.id>ba2.id AND ba1.author_id=?", []interface{}{canonicalId}},
+		{"UPDATE author_aliases SET name=?, sort=? WHERE sort=?", []interface{}{canonical.Name, canonical.Sort, author.Sort}},
+		{"DELETE FROM author_aliases WHERE alias=?", []interface{}{canonical.Sort}},
+		{"INSERT INTO author_aliases (alias, name, sort) VALUES (?, ?, ?)", []interface{}{author.Sort, canonical.Name, canonical.Sort}},
+		{"DELETE FROM authors WHERE id=?", []interface{}{authorId}},
+	}
+	return db.execTx(stmts)
+}
+
+// AuthorAliasSuggestions groups authors that are likely aliases of each other by
+// normalised surname and first name initial
+func (db *DB) AuthorAliasSuggestions() [][]*model.Author {
+	q := `SELECT a.id, a.name, a.sort, count(*) FROM authors as a, books_authors as ba WHERE a.id=ba.author_id GROUP BY a.id ORDER BY a.sort`
+	rows, err := db.Query(q)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	defer rows.Close()
+
+	groups := map[string][]*model.Author{}
+	keys := []string{}
+	for rows.Next() {
+		a := &model.Author{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.Sort, &a.Count); err != nil {
+			log.Println(err)
+			return nil
+		}
+		key := normalize.NameKey(a.Sort)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+	suggestions := [][]*model.Author{}
+	for _, key := range keys {
+		if len(groups[key]) > 1 {
+			suggestions = append(suggestions, groups[key])
+		}
+	}
+	return suggestions
+}
+
 func (db *DB) AuthorsByBookId(bookId int64) []*model.Author {
 	authors := []*model.Author{}
 	q := `SELECT a.id, a.name FROM authors as a, books_authors as ba WHERE ba.book_id=? AND ba.author_id=a.id ORDER BY a.sort`
@@ -294,29 +436,16 @@ func (db *DB) AuthorsByBookId(bookId int64) []*model.Author {
 
 // Genres
 
-func (db *DB) ListGenreBooks(genreCode string, limit, offset int) []*model.Book {
-	q := `SELECT b.id, b.title, b.plot, b.cover FROM books as b, books_genres as bg WHERE bg.genre_code=? AND b.id=bg.book_id ORDER BY b.sort`
-	rows, err := db.pageQuery(q, limit, offset, genreCode)
-	if err != nil {
-		log.Println("DB page query error: ", err.Error())
-	}
-	defer rows.Close()
-	books := []*model.Book{}
-
-	for rows.Next() {
-		b := &model.Book{}
-		if err = rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover); err != nil {
-			log.Fatal(err)
-		}
-		books = append(books, b)
-	}
-	return books
-}
-
-func (db *DB) CountGenreBooks(genreCode string) int64 {
+// CountGenreBooks returns number of the genre books. Books are limited to the book language when it is set
+func (db *DB) CountGenreBooks(genreCode, bookLanguage string) int64 {
 	var c int64 = 0
-	q := "SELECT count(*) FROM books_genres as bg WHERE bg.genre_code=?"
-	err := db.QueryRow(q, genreCode).Scan(&c)
+	q := "SELECT count(DISTINCT bg.book_id) FROM books_genres as bg WHERE bg.genre_code=?"
+	args := []interface{}{genreCode}
+	if bookLanguage != "" {
+		q = "SELECT count(DISTINCT bg.book_id) FROM books_genres as bg, books as b WHERE bg.genre_code=? AND b.id=bg.book_id AND " + bookLanguageCondition
+		args = append(args, bookLanguage)
+	}
+	err := db.QueryRow(q, args...).Scan(&c)
 	if err == sql.ErrNoRows {
 		return 0
 	}
@@ -328,33 +457,19 @@ func (db *DB) NewSerie(s *model.Serie) int64 {
 	if s.Name == "" {
 		return 0
 	}
+	db.resolveSerieAlias(s)
 	id := db.FindSerie(s)
 	if id != 0 {
 		return id
 	}
-	q := "INSERT INTO series (name) VALUES (?)"
-	res, _ := db.Exec(q, s.Name)
-	id, _ = res.LastInsertId()
-	return id
-}
-
-func (db *DB) ListSerieBooks(id int64, limit, offset int) []*model.Book {
-	q := `SELECT b.id, b.title, b.plot, b.cover FROM books as b, books_series as bs WHERE bs.serie_id=? AND b.id=bs.book_id ORDER BY bs.serie_num`
-	rows, err := db.pageQuery(q, limit, offset, id)
+	q := "INSERT INTO series (name, serie_key, search_key) VALUES (?, ?, ?)"
+	res, err := db.Exec(q, s.Name, normalize.Fold(s.Name), normalize.Key(s.Name))
 	if err != nil {
-		log.Println("DB page query error: ", err.Error())
-	}
-	defer rows.Close()
-	books := []*model.Book{}
-
-	for rows.Next() {
-		b := &model.Book{}
-		if err = rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover); err != nil {
-			log.Fatal(err)
-		}
-		books = append(books, b)
+		log.Println(err)
+		return 0
 	}
-	return books
+	id, _ = res.LastInsertId()
+	return id
 }
 
 // select id, substr(name,1,1) as s, count(*) as c FROM series group by s order by name<'а', `name`<'a',`name`;
@@ -429,56 +544,100 @@ func (db *DB) SerieByID(id int64) *model.Serie {
 
 func (db *DB) FindSerie(s *model.Serie) int64 {
 	var id int64 = 0
-	q := "SELECT id FROM series WHERE name LIKE ?"
-	err := db.QueryRow(q, s.Name).Scan(&id)
+	q := "SELECT id FROM series WHERE serie_key=?"
+	err := db.QueryRow(q, normalize.Fold(s.Name)).Scan(&id)
 	if err == sql.ErrNoRows {
 		return 0
 	}
 	return id
 }
 
-// Search
-func (db *DB) SearchBooks(pattern string) []*model.Book {
-	q := `SELECT id, title, plot, cover FROM books WHERE title Like ? ORDER BY sort`
-	rows, err := db.Query(q, fmt.Sprint("%", pattern, "%"))
-	if err != nil {
-		log.Fatal(err)
+// resolveSerieAlias replaces serie name with canonical one if the serie is known alias
+func (db *DB) resolveSerieAlias(s *model.Serie) {
+	q := "SELECT name FROM serie_aliases WHERE alias_key=?"
+	db.QueryRow(q, normalize.Fold(s.Name)).Scan(&s.Name)
+}
+
+// MergeSeries re-points books of the serie to the canonical one and deletes the serie.
+// The serie name is kept as an alias of the canonical serie to survive reindex
+func (db *DB) MergeSeries(serieId, canonicalId int64) error {
+	if serieId == canonicalId {
+		return fmt.Errorf("serie %d can't be merged with itself", serieId)
 	}
-	defer rows.Close()
-	books := []*model.Book{}
+	serie := db.SerieByID(serieId)
+	if serie == nil {
+		return fmt.Errorf("serie %d not found", serieId)
+	}
+	canonical := db.SerieByID(canonicalId)
+	if canonical == nil {
+		return fmt.Errorf("canonical serie %d not found", canonicalId)
+	}
+	stmts := []stmt{
+		{"UPDATE books_series SET serie_id=? WHERE serie_id=?", []interface{}{canonicalId, serieId}},
+		// Books that were in both series would be in the canonical one twice
+		{"DELETE bs1 FROM books_series as bs1, books_series as bs2 WHERE bs1.book_id=bs2.book_id AND bs1.serie_id=bs2.serie_id AND bs1.id>bs2.id AND bs1.serie_id=?", []interface{}{canonicalId}},
+		{"UPDATE serie_aliases SET name=? WHERE name=?", []interface{}{canonical.Name, serie.Name}},
+		{"DELETE FROM serie_aliases WHERE alias_key=?", []interface{}{normalize.Fold(canonical.Name)}},
+		{"INSERT INTO serie_aliases (alias_key, name) VALUES (?, ?)", []interface{}{normalize.Fold(serie.Name), canonical.Name}},
+		{"DELETE FROM series WHERE id=?", []interface{}{serieId}},
+	}
+	return db.execTx(stmts)
+}
 
-	for rows.Next() {
-		b := &model.Book{}
-		if err := rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover); err != nil {
-			log.Fatal(err)
+// RenameSerie renames the serie or merges it into existing serie with the same normalised name.
+// The old name is kept as an alias of the new one to survive reindex
+func (db *DB) RenameSerie(serieId int64, name string) error {
+	name = strings.Join(strings.Fields(name), " ")
+	if normalize.Fold(name) == "" {
+		return fmt.Errorf("serie name %q is empty", name)
+	}
+	serie := db.SerieByID(serieId)
+	if serie == nil {
+		return fmt.Errorf("serie %d not found", serieId)
+	}
+	if id := db.FindSerie(&model.Serie{Name: name}); id != 0 && id != serieId {
+		if err := db.MergeSeries(serieId, id); err != nil {
+			return err
 		}
-		books = append(books, b)
+		serieId, serie.Name = id, db.SerieByID(id).Name
 	}
-	return books
+	stmts := []stmt{
+		{"UPDATE series SET name=?, serie_key=?, search_key=? WHERE id=?", []interface{}{name, normalize.Fold(name), normalize.Key(name), serieId}},
+		{"UPDATE serie_aliases SET name=? WHERE name=?", []interface{}{name, serie.Name}},
+		{"DELETE FROM serie_aliases WHERE alias_key=?", []interface{}{normalize.Fold(name)}},
+	}
+	if normalize.Fold(serie.Name) != normalize.Fold(name) {
+		stmts = append(stmts, stmt{"INSERT INTO serie_aliases (alias_key, name) VALUES (?, ?)", []interface{}{normalize.Fold(serie.Name), name}})
+	}
+	return db.execTx(stmts)
 }
 
-func (db *DB) PageSearchedBooks(pattern string, limit, offset int) []*model.Book {
-	q := `SELECT id, title, plot, cover FROM books WHERE title LIKE ? ORDER BY sort`
-	rows, err := db.pageQuery(q, limit, offset, fmt.Sprint("%", pattern, "%"))
-	if err != nil {
-		log.Fatal(err)
+// AddSerieAlias makes books of the alias serie to be listed in the canonical serie
+// both already indexed and to be indexed
+func (db *DB) AddSerieAlias(alias, name string) error {
+	if normalize.Fold(alias) == "" || normalize.Fold(name) == "" {
+		return fmt.Errorf("serie alias %q or name %q is empty", alias, name)
 	}
-	defer rows.Close()
-	books := []*model.Book{}
-
-	for rows.Next() {
-		b := &model.Book{}
-		if err := rows.Scan(&b.ID, &b.Title, &b.Plot, &b.Cover); err != nil {
-			log.Fatal(err)
+	if normalize.Fold(alias) == normalize.Fold(name) {
+		return fmt.Errorf("serie alias %q is the same as name %q", alias, name)
+	}
+	if aliasId := db.FindSerie(&model.Serie{Name: alias}); aliasId != 0 {
+		if id := db.FindSerie(&model.Serie{Name: name}); id != 0 {
+			return db.MergeSeries(aliasId, id)
 		}
-		books = append(books, b)
+		return db.RenameSerie(aliasId, name)
 	}
-	return books
+	return db.execTx([]stmt{
+		{"DELETE FROM serie_aliases WHERE alias_key=?", []interface{}{normalize.Fold(alias)}},
+		{"INSERT INTO serie_aliases (alias_key, name) VALUES (?, ?)", []interface{}{normalize.Fold(alias), name}},
+	})
 }
 
+// Search
 func (db *DB) SearchAuthors(pattern string) []*model.Author {
-	q := `SELECT id, name, sort FROM authors WHERE sort LIKE ? ORDER BY sort`
-	rows, err := db.Query(q, fmt.Sprint(pattern, "%"))
+	where, args := searchKeyCondition("a.search_key", pattern)
+	q := `SELECT a.id, a.name, a.sort, count(*) FROM authors as a, books_authors as ba WHERE ` + where + ` AND a.id=ba.author_id GROUP BY a.id ORDER BY a.sort`
+	rows, err := db.Query(q, args...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -487,7 +646,7 @@ func (db *DB) SearchAuthors(pattern string) []*model.Author {
 
 	for rows.Next() {
 		a := &model.Author{}
-		if err := rows.Scan(&a.ID, &a.Name, &a.Sort); err != nil {
+		if err := rows.Scan(&a.ID, &a.Name, &a.Sort, &a.Count); err != nil {
 			log.Fatal(err)
 		}
 		authors = append(authors, a)
@@ -495,6 +654,137 @@ func (db *DB) SearchAuthors(pattern string) []*model.Author {
 	return authors
 }
 
+// Batch is the scan batch of books added at once. Books of the batch share the updated time
+type Batch struct {
+	Updated int64
+	Count   int64
+}
+
+// ListBatches returns up to limit latest scan batches since the time
+func (db *DB) ListBatches(since int64, limit int) []*Batch {
+	batches := []*Batch{}
+	q := "SELECT updated, count(*) FROM books WHERE updated>=? GROUP BY updated ORDER BY updated DESC LIMIT ?"
+	rows, err := db.Query(q, since, limit)
+	if err != nil {
+		log.Println(err)
+		return batches
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b := &Batch{}
+		if err := rows.Scan(&b.Updated, &b.Count); err != nil {
+			log.Fatal(err)
+		}
+		batches = append(batches, b)
+	}
+	return batches
+}
+
+// Suggestions
+func (db *DB) StockStamp() string {
+	var c, u int64
+	var a int64
+	var s int64
+	q := "SELECT count(*), coalesce(max(updated), 0), (SELECT count(*) FROM authors), (SELECT count(*) FROM series) FROM books"
+	db.QueryRow(q).Scan(&c, &u, &a, &s)
+	return fmt.Sprint(c, ":", u, ":", a, ":", s)
+}
+
+func (db *DB) ListSuggestions() []*search.Suggestion {
+	suggestions := []*search.Suggestion{}
+	queries := []struct {
+		kind string
+		q    string
+	}{
+		{search.AuthorSuggestion, `SELECT a.id, a.sort, count(*) FROM authors as a, books_authors as ba WHERE a.id=ba.author_id GROUP BY a.id`},
+		{search.SerieSuggestion, `SELECT s.id, s.name, count(*) FROM series as s, books_series as bs WHERE s.id=bs.serie_id GROUP BY s.id`},
+		{search.TitleSuggestion, `SELECT id, title, 0 FROM books`},
+	}
+	for _, sq := range queries {
+		rows, err := db.Query(sq.q)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for rows.Next() {
+			s := &search.Suggestion{Kind: sq.kind}
+			if err := rows.Scan(&s.ID, &s.Label, &s.Weight); err != nil {
+				log.Println(err)
+				break
+			}
+			suggestions = append(suggestions, s)
+		}
+		rows.Close()
+	}
+	return suggestions
+}
+
+// searchQueryCondition returns SQL condition for books table aliased as "b" combining all the query fields
+func searchQueryCondition(sq *search.Query) (string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+	add := func(cond string, condArgs ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	if text := strings.TrimSpace(sq.Text + " " + sq.Title); text != "" {
+		where, whereArgs := searchKeyCondition("b.search_key", text)
+		add(where, whereArgs...)
+	}
+	if sq.Author != "" {
+		where, whereArgs := searchKeyCondition("a.search_key", sq.Author)
+		add(`EXISTS (SELECT 1 FROM books_authors as ba, authors as a WHERE ba.book_id=b.id AND a.id=ba.author_id AND `+where+`)`, whereArgs...)
+	}
+	if sq.Serie != "" {
+		where, whereArgs := searchKeyCondition("s.search_key", sq.Serie)
+		add(`EXISTS (SELECT 1 FROM books_series as bs, series as s WHERE bs.book_id=b.id AND s.id=bs.serie_id AND `+where+`)`, whereArgs...)
+	}
+	if len(sq.Languages) > 0 {
+		add(`b.language_id IN (SELECT id FROM languages WHERE code IN (?`+strings.Repeat(", ?", len(sq.Languages)-1)+`))`, stringArgs(sq.Languages)...)
+	}
+	if len(sq.Genres) > 0 {
+		add(`EXISTS (SELECT 1 FROM books_genres as bg WHERE bg.book_id=b.id AND bg.genre_code IN (?`+strings.Repeat(", ?", len(sq.Genres)-1)+`))`, stringArgs(sq.Genres)...)
+	}
+	if sq.YearFrom != "" {
+		add(`b.year>=?`, sq.YearFrom)
+	}
+	if sq.YearTo != "" {
+		add(`b.year<>'' AND b.year<=?`, sq.YearTo)
+	}
+	if len(conds) == 0 {
+		return "1=0", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func stringArgs(ss []string) []interface{} {
+	args := make([]interface{}, len(ss))
+	for i := range ss {
+		args[i] = ss[i]
+	}
+	return args
+}
+
+// searchKeyCondition returns SQL condition to match every pattern word in the search key column
+// regardless of the script, letter case, diacritics and transliteration spelling
+func searchKeyCondition(column, pattern string) (string, []interface{}) {
+	keys := normalize.Keys(pattern)
+	if len(keys) == 0 {
+		return "1=0", nil
+	}
+	words := []string{}
+	args := []interface{}{}
+	for _, variants := range keys {
+		vs := []string{}
+		for _, v := range variants {
+			vs = append(vs, column+" LIKE ?")
+			args = append(args, "%"+v+"%")
+		}
+		words = append(words, "("+strings.Join(vs, " OR ")+")")
+	}
+	return strings.Join(words, " AND "), args
+}
+
 // ==================================
 func NewDB(dsn string) *DB {
 	db, err := sql.Open("mysql", dsn)
@@ -522,7 +812,7 @@ func (db *DB) DropDB(dropSQL string) {
 
 func (db *DB) IsReady() bool {
 	var err error
-	rows, err := db.Query("SHOW TABLES")
+	rows, err := db.Query("SHOW TABLES LIKE 'books'")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -530,6 +820,84 @@ func (db *DB) IsReady() bool {
 	return rows.Next()
 }
 
+// UpdateSearchKeys adds search key columns to the tables of databases inited before search keys
+// were introduced and fills in the missing keys
+func (db *DB) UpdateSearchKeys() {
+	db.updateKeys("authors", "search_key", "VARCHAR(256)", "sort", normalize.Key)
+	db.updateKeys("books", "search_key", "VARCHAR(1024)", "title", normalize.Key)
+	db.updateKeys("series", "search_key", "VARCHAR(512)", "name", normalize.Key)
+}
+
+// UpdateSerieKeys adds serie keys and author and serie alias tables to databases inited before them
+func (db *DB) UpdateSerieKeys() {
+	if db.updateKeys("series", "serie_key", "VARCHAR(256)", "name", normalize.Fold) {
+		if _, err := db.Exec("CREATE INDEX series_serie_key_idx ON series (serie_key)"); err != nil {
+			log.Println(err)
+		}
+	}
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS author_aliases (
+			id INTEGER PRIMARY KEY AUTO_INCREMENT,
+			alias VARCHAR(128) NOT NULL,
+			name VARCHAR(128) NOT NULL,
+			sort VARCHAR(128) NOT NULL,
+			INDEX author_aliases_alias_idx (alias),
+			INDEX author_aliases_sort_idx (sort)
+		)`,
+		`CREATE TABLE IF NOT EXISTS serie_aliases (
+			id INTEGER PRIMARY KEY AUTO_INCREMENT,
+			alias_key VARCHAR(256) NOT NULL,
+			name VARCHAR(256) NOT NULL,
+			INDEX serie_aliases_alias_key_idx (alias_key),
+			INDEX serie_aliases_name_idx (name)
+		)`,
+	}
+	for _, q := range stmts {
+		if _, err := db.Exec(q); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// updateKeys adds the key column to the table when it is missing and fills in empty keys
+// made of the source column. It reports whether the column was added
+func (db *DB) updateKeys(table, column, columnType, source string, key func(string) string) bool {
+	var n int
+	q := "SELECT count(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name=? AND column_name=?"
+	if err := db.QueryRow(q, table, column).Scan(&n); err != nil {
+		log.Println(err)
+		return false
+	}
+	if n == 0 {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s NOT NULL DEFAULT ''", table, column, columnType)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE %s=''", source, table, column))
+	if err != nil {
+		log.Println(err)
+		return n == 0
+	}
+	keys := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var s string
+		if err := rows.Scan(&id, &s); err != nil {
+			log.Fatal(err)
+		}
+		if k := key(s); k != "" {
+			keys[id] = k
+		}
+	}
+	rows.Close()
+	for id, k := range keys {
+		if _, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s=? WHERE id=?", table, column), k, id); err != nil {
+			log.Println(err)
+		}
+	}
+	return n == 0
+}
+
 func (db *DB) execFile(sqlFile string) {
 	file, err := os.Open(sqlFile)
 	if err != nil {
@@ -541,7 +909,11 @@ func (db *DB) execFile(sqlFile string) {
 	q := ""
 
 	for scanner.Scan() {
-		q += scanner.Text()
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		q += line + "\n"
 		if strings.Contains(q, ";") {
 			_, err := db.Exec(q)
 			q = ""
@@ -552,16 +924,22 @@ func (db *DB) execFile(sqlFile string) {
 	}
 }
 
-func (db *DB) pageQuery(query string, limit, offset int, args ...interface{}) (*sql.Rows, error) {
-	if limit > 0 {
-		query += " LIMIT ?"
-		args = append(args, limit)
+type stmt struct {
+	q    string
+	args []interface{}
+}
+
+// execTx executes statements in a transaction
+func (db *DB) execTx(stmts []stmt) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
-	if offset > 0 {
-		query += " OFFSET ?"
-		args = append(args, offset)
+	defer tx.Rollback()
+	for _, st := range stmts {
+		if _, err := tx.Exec(st.q, st.args...); err != nil {
+			return err
+		}
 	}
-	// log.Println("query: ", query, " args: ", args)
-	rows, err := db.Query(query, args...)
-	return rows, err
+	return tx.Commit()
 }
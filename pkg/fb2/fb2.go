@@ -62,15 +62,50 @@ func (fb *FB2) String() string {
 }
 
 type TitleInfo struct {
-	Authors    []Author   `xml:"author"`
-	Title      string     `xml:"book-title"`
-	Gengres    []string   `xml:"genre"`
-	Annotation Annotation `xml:"annotation"`
-	Date       string     `xml:"date"`
-	Year       string     `xml:"year"`
-	Lang       string     `xml:"lang"`
-	Serie      Serie      `xml:"sequence"`
-	CoverPage  Image      `xml:"coverpage>image"`
+	Authors     []Author   `xml:"author"`
+	Title       string     `xml:"book-title"`
+	Gengres     []string   `xml:"genre"`
+	Annotation  Annotation `xml:"annotation"`
+	Date        string     `xml:"date"`
+	Year        string     `xml:"year"`
+	Lang        string     `xml:"lang"`
+	SrcLang     string     `xml:"src-lang"`
+	Translators []Author   `xml:"translator"`
+	Serie       Serie      `xml:"sequence"`
+	CoverPage   Image      `xml:"coverpage>image"`
+}
+
+// Description is the whole FB2 book description with title and publishing info
+type Description struct {
+	TitleInfo   TitleInfo   `xml:"title-info"`
+	PublishInfo PublishInfo `xml:"publish-info"`
+}
+
+type PublishInfo struct {
+	BookName  string `xml:"book-name"`
+	Publisher string `xml:"publisher"`
+	City      string `xml:"city"`
+	Year      string `xml:"year"`
+	ISBN      string `xml:"isbn"`
+}
+
+// NewDescription reads FB2 book description
+func NewDescription(rc io.Reader) (*Description, error) {
+	decoder := xml.NewDecoder(rc)
+	decoder.CharsetReader = charset.NewReaderLabel
+	d := &Description{}
+	for {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if se, ok := t.(xml.StartElement); ok && se.Name.Local == "description" {
+			if err := decoder.DecodeElement(d, &se); err != nil {
+				return nil, err
+			}
+			return d, nil
+		}
+	}
 }
 
 type Author struct {
@@ -218,12 +253,31 @@ func (fb *FB2) GetAuthors() []*model.Author {
 	return authors
 }
 
+func (fb *FB2) GetTranslators() []*model.Author {
+	translators := make([]*model.Author, 0, len(fb.Translators))
+	for _, a := range fb.Translators {
+		f := refineName(a.FirstName, fb.Lang)
+		m := refineName(a.MiddleName, fb.Lang)
+		l := refineName(a.LastName, fb.Lang)
+		translators = append(translators, &model.Author{
+			Name: CollapseSpaces(fmt.Sprintf("%s %s %s", f, m, l)),
+			Sort: CollapseSpaces(fmt.Sprintf("%s, %s %s", l, f, m)),
+		})
+	}
+	return translators
+}
+
+// GetAnnotation returns full book annotation, not truncated as the plot
+func (fb *FB2) GetAnnotation() string {
+	return stripNonprintables(fb.Annotation.Text)
+}
+
 func (fb *FB2) GetGenres() []string {
 	return fb.Gengres
 }
 
 func (fb *FB2) GetSerie() *model.Serie {
-	return &model.Serie{Name: fb.Serie.Name}
+	return &model.Serie{Name: strings.TrimSpace(CollapseSpaces(fb.Serie.Name))}
 }
 
 func (fb *FB2) GetSerieNumber() int {
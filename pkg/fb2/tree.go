@@ -0,0 +1,116 @@
+package fb2
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Node is FB2 document element. Character data is kept as child nodes with empty name,
+// so mixed content order is preserved
+type Node struct {
+	Name     string
+	Attr     []xml.Attr
+	Children []*Node
+	Text     string
+}
+
+// ParseTree reads the whole FB2 document as a tree of nodes and returns the root FictionBook element
+func ParseTree(r io.Reader) (*Node, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	root := &Node{}
+	stack := []*Node{root}
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch se := t.(type) {
+		case xml.StartElement:
+			n := &Node{Name: se.Name.Local, Attr: se.Copy().Attr}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Children = append(parent.Children, &Node{Text: string(se)})
+		}
+	}
+	if fb := root.Child("FictionBook"); fb != nil {
+		return fb, nil
+	}
+	return root, nil
+}
+
+// IsText reports whether the node is character data
+func (n *Node) IsText() bool {
+	return n.Name == ""
+}
+
+// Child returns the first child element of the name or nil
+func (n *Node) Child(name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChildrenNamed returns the child elements of the name
+func (n *Node) ChildrenNamed(name string) []*Node {
+	nodes := []*Node{}
+	for _, c := range n.Children {
+		if c.Name == name {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// Path returns the descendant element found by child names path or nil
+func (n *Node) Path(names ...string) *Node {
+	for _, name := range names {
+		if n = n.Child(name); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// AttrValue returns the value of attribute of the local name regardless of its namespace
+func (n *Node) AttrValue(name string) string {
+	for _, a := range n.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Content returns the text of the node and its descendants with collapsed spaces
+func (n *Node) Content() string {
+	var sb strings.Builder
+	n.content(&sb)
+	return strings.TrimSpace(CollapseSpaces(sb.String()))
+}
+
+func (n *Node) content(sb *strings.Builder) {
+	if n.IsText() {
+		sb.WriteString(n.Text)
+		return
+	}
+	for _, c := range n.Children {
+		c.content(sb)
+	}
+}
@@ -1,9 +1,10 @@
 package model
 
 type Language struct {
-	ID   int64
-	Code string
-	Name string
+	ID    int64
+	Code  string
+	Name  string
+	Count int // for intermediate storing language book counts
 }
 
 type Author struct {
@@ -0,0 +1,153 @@
+package normalize
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fold returns case folded string with ё replaced by е, diacritics stripped,
+// punctuation replaced by spaces and surplus spaces removed
+func Fold(s string) string {
+	b := strings.Builder{}
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == 'ё':
+			b.WriteRune('е')
+		case r == 'й':
+			b.WriteRune(r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			for _, d := range norm.NFD.String(string(r)) {
+				if !unicode.Is(unicode.Mn, d) {
+					b.WriteRune(d)
+				}
+			}
+		case unicode.Is(unicode.Mn, r):
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+var cyrToLat = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// ToLatin transliterates Cyrillic letters of the string to Latin ones keeping the letter case
+func ToLatin(s string) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		l, ok := cyrToLat[unicode.ToLower(r)]
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case unicode.IsUpper(r) && len(l) > 0:
+			b.WriteString(strings.ToUpper(l[:1]) + l[1:])
+		default:
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+// Latin letter combinations in the order of matching priority
+var latToCyr = []struct{ lat, cyr string }{
+	{"shch", "щ"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"}, {"sh", "ш"},
+	{"yu", "ю"}, {"ya", "я"}, {"yo", "ё"}, {"ye", "е"}, {"iy", "ий"}, {"yy", "ый"},
+	{"a", "а"}, {"b", "б"}, {"c", "к"}, {"d", "д"}, {"e", "е"}, {"f", "ф"}, {"g", "г"},
+	{"h", "х"}, {"i", "и"}, {"j", "й"}, {"k", "к"}, {"l", "л"}, {"m", "м"}, {"n", "н"},
+	{"o", "о"}, {"p", "п"}, {"q", "к"}, {"r", "р"}, {"s", "с"}, {"t", "т"}, {"u", "у"},
+	{"v", "в"}, {"w", "в"}, {"x", "кс"}, {"z", "з"},
+}
+
+// ToCyrillic transliterates Latin letters of the string to Cyrillic ones keeping the letter case
+func ToCyrillic(s string) string {
+	b := strings.Builder{}
+	rs := []rune(s)
+NextRune:
+	for i := 0; i < len(rs); {
+		for _, lc := range latToCyr {
+			n := len(lc.lat)
+			if i+n > len(rs) || strings.ToLower(string(rs[i:i+n])) != lc.lat {
+				continue
+			}
+			writeCase(&b, lc.cyr, unicode.IsUpper(rs[i]))
+			i += n
+			continue NextRune
+		}
+		switch {
+		case rs[i] == 'y' || rs[i] == 'Y':
+			// "y" after a vowel is a short "й", otherwise it is "ы"
+			c := "ы"
+			if i > 0 && strings.ContainsRune("aeiouAEIOU", rs[i-1]) {
+				c = "й"
+			}
+			writeCase(&b, c, unicode.IsUpper(rs[i]))
+		default:
+			b.WriteRune(rs[i])
+		}
+		i++
+	}
+	return b.String()
+}
+
+func writeCase(b *strings.Builder, s string, upper bool) {
+	if !upper {
+		b.WriteString(s)
+		return
+	}
+	rs := []rune(s)
+	b.WriteRune(unicode.ToUpper(rs[0]))
+	b.WriteString(string(rs[1:]))
+}
+
+// Spelling variants of transliterated Latin that are reduced to the same skeleton
+var keyReplacer = strings.NewReplacer(
+	"ye", "e", "ph", "f", "kh", "h", "ck", "k", "x", "ks", "w", "v", "q", "k", "j", "i", "y", "i",
+)
+
+// Key returns the search key of the string. The key is Latin skeleton of the folded
+// and transliterated string, so "Tolstoj", "Tolstoy" and "Толстой" have the same key
+func Key(s string) string {
+	s = keyReplacer.Replace(ToLatin(Fold(s)))
+	b := strings.Builder{}
+	var last rune
+	for _, r := range s {
+		if r == last && unicode.IsLetter(r) {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+	return b.String()
+}
+
+// Keys returns distinct search keys of the string words both as is and transliterated to Cyrillic
+func Keys(s string) [][]string {
+	keys := [][]string{}
+	for _, w := range strings.Fields(Fold(s)) {
+		variants := []string{Key(w)}
+		if k := Key(ToCyrillic(w)); k != variants[0] {
+			variants = append(variants, k)
+		}
+		keys = append(keys, variants)
+	}
+	return keys
+}
+
+// NameKey returns the key of author sort name like "Surname, First Middle" made of
+// the surname search key and the first name initial
+func NameKey(sort string) string {
+	surname, names, _ := strings.Cut(sort, ",")
+	key := Key(surname)
+	if first := []rune(Key(names)); len(first) > 0 {
+		key += " " + string(first[0])
+	}
+	return key
+}
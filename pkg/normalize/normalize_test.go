@@ -0,0 +1,66 @@
+package normalize
+
+import "testing"
+
+func TestFold(t *testing.T) {
+	var testArgs = []struct {
+		arg, expected string
+	}{
+		{"Ёжик в  тумане", "ежик в тумане"},
+		{"Les Misérables", "les miserables"},
+		{"Толстой, Лев", "толстой лев"},
+		{" Pratchett-Baxter ", "pratchett baxter"},
+	}
+	for _, ta := range testArgs {
+		if got := Fold(ta.arg); got != ta.expected {
+			t.Errorf("Fold(%q): expecting %q, got: %q", ta.arg, ta.expected, got)
+		}
+	}
+}
+
+func TestTransliteration(t *testing.T) {
+	if got := ToLatin("Щукин Юрий"); got != "Shchukin Yuriy" {
+		t.Errorf("ToLatin: expecting %q, got: %q", "Shchukin Yuriy", got)
+	}
+	if got := ToCyrillic("Tolstoy"); got != "Толстой" {
+		t.Errorf("ToCyrillic: expecting %q, got: %q", "Толстой", got)
+	}
+	if got := ToCyrillic("Zhukovskiy"); got != "Жуковский" {
+		t.Errorf("ToCyrillic: expecting %q, got: %q", "Жуковский", got)
+	}
+}
+
+func TestKey(t *testing.T) {
+	var testArgs = [][]string{
+		{"Tolstoj", "Tolstoy", "Толстой", "ТОЛСТОЙ"},
+		{"Стругацкий", "Strugatsky", "Strugatskij"},
+		{"Достоевский", "Dostoyevsky", "Dostoevskiy"},
+		{"Алексей", "Alexei", "Aleksey"},
+		{"Ёлка", "Елка"},
+	}
+	for _, ta := range testArgs {
+		expected := Key(ta[0])
+		for _, arg := range ta[1:] {
+			if got := Key(arg); got != expected {
+				t.Errorf("Key(%q): expecting %q, got: %q", arg, expected, got)
+			}
+		}
+	}
+}
+
+func TestNameKey(t *testing.T) {
+	var testArgs = []string{
+		"Стругацкий, Аркадий",
+		"Стругацкий, Аркадий Натанович",
+		"Strugatsky, Arkady",
+	}
+	expected := "strugatski a"
+	for _, arg := range testArgs {
+		if got := NameKey(arg); got != expected {
+			t.Errorf("NameKey(%q): expecting %q, got: %q", arg, expected, got)
+		}
+	}
+	if got := NameKey("Стругацкий, Борис"); got == expected {
+		t.Errorf("NameKey(%q): expecting other than %q", "Стругацкий, Борис", expected)
+	}
+}
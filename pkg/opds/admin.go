@@ -0,0 +1,144 @@
+package opds
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/normalize"
+)
+
+// Admin API
+func (h *Handler) admin(w http.ResponseWriter, r *http.Request) {
+	h.LOG.I.Println(commentURL("Admin", r))
+	if h.CFG.Admin.TOKEN == "" {
+		writeMessage(w, http.StatusNotFound, "Admin API is disabled")
+		return
+	}
+	// Token is accepted from Authorization header only, so it never gets to the logged URLs
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.CFG.Admin.TOKEN)) != 1 {
+		writeMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	switch r.URL.Path {
+	case "/admin/authors/merge":
+		h.mergeAuthors(w, r)
+	case "/admin/authors/aliases":
+		h.authorAliases(w, r)
+	case "/admin/series/merge":
+		h.mergeSeries(w, r)
+	case "/admin/series/rename":
+		h.renameSerie(w, r)
+	case "/admin/series/alias":
+		h.addSerieAlias(w, r)
+	default:
+		writeMessage(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// POST /admin/authors/merge?id=""&into="" - merge author into the canonical one
+func (h *Handler) mergeAuthors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	authorId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	canonicalId, _ := strconv.ParseInt(r.FormValue("into"), 10, 64)
+	if err := h.DB.MergeAuthors(authorId, canonicalId); err != nil {
+		h.LOG.E.Printf("failed to merge author %d into %d: %s\n", authorId, canonicalId, err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.LOG.I.Printf("author %d has been merged into %d\n", authorId, canonicalId)
+	writeJSON(w, http.StatusOK, map[string]int64{"id": canonicalId})
+}
+
+// POST /admin/series/merge?id=""&into="" - merge serie into the canonical one
+func (h *Handler) mergeSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	serieId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	canonicalId, _ := strconv.ParseInt(r.FormValue("into"), 10, 64)
+	if err := h.DB.MergeSeries(serieId, canonicalId); err != nil {
+		h.LOG.E.Printf("failed to merge serie %d into %d: %s\n", serieId, canonicalId, err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.LOG.I.Printf("serie %d has been merged into %d\n", serieId, canonicalId)
+	writeJSON(w, http.StatusOK, map[string]int64{"id": canonicalId})
+}
+
+// POST /admin/series/rename?id=""&name="" - rename serie
+func (h *Handler) renameSerie(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	serieId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	name := r.FormValue("name")
+	if err := h.DB.RenameSerie(serieId, name); err != nil {
+		h.LOG.E.Printf("failed to rename serie %d to %q: %s\n", serieId, name, err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.LOG.I.Printf("serie %d has been renamed to %q\n", serieId, name)
+	writeJSON(w, http.StatusOK, map[string]string{"name": name})
+}
+
+// POST /admin/series/alias?alias=""&name="" - add alias name of the serie
+func (h *Handler) addSerieAlias(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	alias := r.FormValue("alias")
+	name := r.FormValue("name")
+	if err := h.DB.AddSerieAlias(alias, name); err != nil {
+		h.LOG.E.Printf("failed to add serie alias %q of %q: %s\n", alias, name, err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.LOG.I.Printf("serie alias %q of %q has been added\n", alias, name)
+	writeJSON(w, http.StatusOK, map[string]string{"alias": alias, "name": name})
+}
+
+type aliasGroup struct {
+	Key     string        `json:"key"`
+	Authors []aliasAuthor `json:"authors"`
+}
+
+type aliasAuthor struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Sort  string `json:"sort"`
+	Count int    `json:"books"`
+}
+
+// GET /admin/authors/aliases - likely author aliases to be merged
+func (h *Handler) authorAliases(w http.ResponseWriter, r *http.Request) {
+	groups := []aliasGroup{}
+	for _, authors := range h.DB.AuthorAliasSuggestions() {
+		g := aliasGroup{Key: normalize.NameKey(authors[0].Sort)}
+		for _, a := range authors {
+			g.Authors = append(g.Authors, aliasAuthor{ID: a.ID, Name: a.Name, Sort: a.Sort, Count: a.Count})
+		}
+		groups = append(groups, g)
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
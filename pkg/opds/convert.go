@@ -0,0 +1,144 @@
+package opds
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/convert"
+	"github.com/vinser/flibgo/pkg/model"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// bookFormat is the format FB2 books are converted to on download
+type bookFormat struct {
+	ext       string
+	mediaType string
+	convert   func(c *convert.Converter, r io.Reader, w io.Writer) error
+}
+
+var bookFormats = map[string]bookFormat{
+	"epub":       {".epub", convert.EPUBType, (*convert.Converter).EPUB},
+	"mobi":       {".mobi", convert.MOBIType, (*convert.Converter).MOBI},
+	"html":       {".html", convert.HTMLType, (*convert.Converter).HTML},
+	"txt":        {".txt", convert.TXTType, (*convert.Converter).TXT},
+	"txt-cp1251": {".txt", TXT1251Type, txt1251},
+}
+
+// TXT1251Type is plain text media type in windows-1251 charset
+const TXT1251Type = "text/plain; charset=windows-1251"
+
+// txt1251 converts book to plain text in windows-1251 charset for older devices.
+// Characters out of the charset are replaced
+func txt1251(c *convert.Converter, r io.Reader, w io.Writer) error {
+	tw := transform.NewWriter(w, encoding.ReplaceUnsupported(charmap.Windows1251.NewEncoder()))
+	if err := c.TXT(r, tw); err != nil {
+		return err
+	}
+	// Closing flushes the text buffered by the encoder
+	return tw.Close()
+}
+
+// defaultFormats are book formats offered to the devices without profile
+var defaultFormats = []string{"fb2", "fb2.zip", "epub", "mobi", "html", "txt", "txt-cp1251"}
+
+// deviceFormats returns book formats preferred by the device profile matching the request
+func (h *Handler) deviceFormats(r *http.Request) []string {
+	for _, d := range h.CFG.OPDS.DEVICES {
+		if len(d.FORMATS) > 0 && d.Matches(r.UserAgent(), r.Header.Get("Accept")) {
+			return d.FORMATS
+		}
+	}
+	return defaultFormats
+}
+
+// formatKey returns book format key of the request, text charset is given by charset parameter.
+// Device preferred format is used unless the format or the document type is given
+func (h *Handler) formatKey(r *http.Request) string {
+	key := r.FormValue("format")
+	switch {
+	case key == "" && r.FormValue("type") == "" && len(h.formats) > 0:
+		key = h.formats[0]
+	case key == "txt":
+		switch strings.ToLower(r.FormValue("charset")) {
+		case "windows-1251", "cp1251":
+			key = "txt-cp1251"
+		}
+	}
+	return key
+}
+
+// acquisitionLinks returns the book download links in the order of device preferred formats
+func (h *Handler) acquisitionLinks(book *model.Book) []Link {
+	links := []Link{}
+	for _, key := range h.formats {
+		link := Link{Rel: "http://opds-spec.org/acquisition/open-access"}
+		switch key {
+		case "fb2":
+			link.Href = fmt.Sprint("/opds/books?id=", book.ID, "&format=fb2")
+			link.Type = "application/fb2"
+			link.Length = fmt.Sprint(book.Size)
+		case "fb2.zip":
+			link.Href = fmt.Sprint("/opds/books?id=", book.ID, "&format=fb2.zip")
+			link.Type = FB2ZipType
+		case "txt-cp1251":
+			link.Href = fmt.Sprint("/opds/books?id=", book.ID, "&format=txt&charset=windows-1251")
+			link.Type = TXT1251Type
+			link.Title = h.P.Sprintf("Plain text (windows-1251)")
+		default:
+			format, ok := bookFormats[key]
+			if !ok {
+				continue
+			}
+			link.Href = fmt.Sprint("/opds/books?id=", book.ID, "&format=", key)
+			link.Type = format.mediaType
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// GET /opds/books?id=""&format=epub|mobi|html|txt[&charset=windows-1251] - download the book converted to the format
+func (h *Handler) unloadConverted(w http.ResponseWriter, r *http.Request) {
+	key := h.formatKey(r)
+	format, ok := bookFormats[key]
+	if !ok {
+		writeMessage(w, http.StatusBadRequest, h.P.Sprintf("Unsupported format"))
+		return
+	}
+	bookId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	book := h.DB.FindBookById(bookId)
+	if book == nil {
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	if notModified(w, r, book, key) {
+		return
+	}
+	rsc, err := h.convertedBook(book, key)
+	if err != nil {
+		h.LOG.E.Printf("failed to convert book %d to %s: %s\n", book.ID, key, err)
+		writeMessage(w, http.StatusInternalServerError, h.P.Sprintf("Book conversion failed"))
+		return
+	}
+	defer rsc.Close()
+	serveBook(w, r, book, key, rsc, h.bookFileName(book, strings.TrimPrefix(format.ext, ".")), format.mediaType)
+}
+
+// convertedBook returns the book converted to the format. Converted books are cached
+func (h *Handler) convertedBook(book *model.Book, key string) (io.ReadSeekCloser, error) {
+	format := bookFormats[key]
+	c := &convert.Converter{GenreName: h.genreName}
+	return h.cachedBook(book, key, format.ext, func(w io.Writer) error {
+		src, err := h.openBook(book)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return format.convert(c, src, w)
+	})
+}
@@ -0,0 +1,49 @@
+package opds
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vinser/flibgo/pkg/config"
+	"github.com/vinser/flibgo/pkg/convert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestFormatKey(t *testing.T) {
+	h := &Handler{CFG: config.LoadConfig("../../config/config.yml")}
+	kindle := "Mozilla/5.0 (X11; U; Linux armv7l like Android; en-us) AppleWebKit/531.2+ (KHTML, like Gecko) Version/5.0 Safari/533.2+ Kindle/3.0+"
+	for _, tc := range []struct {
+		userAgent, url, expected string
+	}{
+		{kindle, "/opds/books?id=1", "mobi"},
+		{kindle, "/opds/books?id=1&type=entry", ""},
+		{kindle, "/opds/books?id=1&format=fb2", "fb2"},
+		{kindle, "/opds/books?id=1&format=txt&charset=windows-1251", "txt-cp1251"},
+		{"", "/opds/books?id=1", "fb2"},
+		{"", "/opds/books?id=1&type=entry", ""},
+	} {
+		r := httptest.NewRequest("GET", tc.url, nil)
+		r.Header.Set("User-Agent", tc.userAgent)
+		h.formats = h.deviceFormats(r)
+		if got := h.formatKey(r); got != tc.expected {
+			t.Errorf("formatKey(%q) of %q: expecting %q, got: %q", tc.url, tc.userAgent, tc.expected, got)
+		}
+	}
+}
+
+func TestTXT1251(t *testing.T) {
+	book := `<?xml version="1.0" encoding="utf-8"?><FictionBook><body><p>Привет, мир!</p></body></FictionBook>`
+	buf := &bytes.Buffer{}
+	if err := txt1251(&convert.Converter{}, strings.NewReader(book), buf); err != nil {
+		t.Fatal(err)
+	}
+	text, err := charmap.Windows1251.NewDecoder().String(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Привет, мир!") {
+		t.Errorf("expecting windows-1251 encoded text, got: %q", text)
+	}
+}
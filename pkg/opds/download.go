@@ -0,0 +1,202 @@
+package opds
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vinser/flibgo/pkg/model"
+)
+
+// sectionCloser is archive member section closed along with the archive file
+type sectionCloser struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (sc *sectionCloser) Close() error {
+	return sc.f.Close()
+}
+
+// bytesCloser is in-memory book content
+type bytesCloser struct {
+	*bytes.Reader
+}
+
+func (bytesCloser) Close() error {
+	return nil
+}
+
+// bookETag returns entity tag of the book variant, e.g. converted format or cover
+func bookETag(book *model.Book, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf(`"%08x"`, book.CRC32)
+	}
+	return fmt.Sprintf(`"%08x-%s"`, book.CRC32, variant)
+}
+
+// bookModified returns the time the book was added to the stock
+func bookModified(book *model.Book) time.Time {
+	if book.Updated == 0 {
+		return time.Time{}
+	}
+	return time.Unix(book.Updated, 0)
+}
+
+// serveBook serves the book variant content with Content-Length, ranges, ETag, Last-Modified and conditional requests
+func serveBook(w http.ResponseWriter, r *http.Request, book *model.Book, variant string, content io.ReadSeeker, fileName, mediaType string) {
+	w.Header().Set("Content-Disposition", contentDisposition(fileName))
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("ETag", bookETag(book, variant))
+	http.ServeContent(w, r, fileName, bookModified(book), content)
+}
+
+// notModified answers conditional GET for the book variant before its content is prepared.
+// It reports whether the client copy is still valid
+func notModified(w http.ResponseWriter, r *http.Request, book *model.Book, variant string) bool {
+	etag := bookETag(book, variant)
+	w.Header().Set("ETag", etag)
+	modified := bookModified(book)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == etag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(ims) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// seekBook opens the book for random access. Stored archive members are read in place,
+// deflated ones are extracted to the cache directory
+func (h *Handler) seekBook(book *model.Book) (io.ReadSeekCloser, error) {
+	if book.Archive == "" {
+		return os.Open(path.Join(h.CFG.Library.BOOK_STOCK, book.File))
+	}
+	zr, file, err := h.archiveFile(book)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	if file.Method == zip.Store {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path.Join(h.CFG.Library.BOOK_STOCK, book.Archive))
+		if err != nil {
+			return nil, err
+		}
+		return &sectionCloser{SectionReader: io.NewSectionReader(f, offset, int64(file.UncompressedSize64)), f: f}, nil
+	}
+	return h.cachedBook(book, book.Format, filepath.Ext(book.File), func(w io.Writer) error {
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	})
+}
+
+// cachedBook returns the book variant from the cache directory, the variant is written by write func
+// when it is not cached yet. Files are named by book id and crc32, so changed books are written again.
+// Variants are kept in memory when there is no cache directory
+func (h *Handler) cachedBook(book *model.Book, variant, ext string, write func(w io.Writer) error) (io.ReadSeekCloser, error) {
+	dir := h.CFG.Library.CACHE
+	if dir == "" {
+		buf := &bytes.Buffer{}
+		if err := write(buf); err != nil {
+			return nil, err
+		}
+		return bytesCloser{bytes.NewReader(buf.Bytes())}, nil
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%d-%08x-%s%s", book.ID, book.CRC32, variant, ext))
+	if f, err := os.Open(name); err == nil {
+		// Modification time of cached files is their last use time for eviction
+		now := time.Now()
+		os.Chtimes(name, now, now)
+		return f, nil
+	}
+	// Variant is written to temporary file and renamed, so incomplete files are never served
+	tmp, err := os.CreateTemp(dir, "cache-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return nil, err
+	}
+	// The file is opened before eviction, so it is served even when it is evicted at once
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	go h.evictCache()
+	return f, nil
+}
+
+var evicting sync.Mutex
+
+// evictCache removes least recently used files from the cache directory when it outgrows CACHE_SIZE megabytes
+func (h *Handler) evictCache() {
+	limit := int64(h.CFG.Library.CACHE_SIZE) << 20
+	if limit <= 0 || !evicting.TryLock() {
+		return
+	}
+	defer evicting.Unlock()
+	dir := h.CFG.Library.CACHE
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		h.LOG.E.Print(err)
+		return
+	}
+	files := []fs.FileInfo{}
+	total := int64(0)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), "cache-") {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, fi)
+			total += fi.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err == nil {
+			total -= fi.Size()
+		}
+	}
+}
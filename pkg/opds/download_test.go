@@ -0,0 +1,184 @@
+package opds
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vinser/flibgo/pkg/config"
+	"github.com/vinser/flibgo/pkg/model"
+)
+
+const testBookContent = `<?xml version="1.0" encoding="utf-8"?><FictionBook><body><p>Hello, world!</p></body></FictionBook>`
+
+// testHandler returns handler of the stock with test.zip archive having stored.fb2 and deflated.fb2 books
+func testHandler(t *testing.T) *Handler {
+	dir := t.TempDir()
+	h := &Handler{CFG: &config.Config{}}
+	h.CFG.Library.BOOK_STOCK = dir
+	h.CFG.Library.CACHE = filepath.Join(dir, "cache")
+	if err := os.Mkdir(h.CFG.Library.CACHE, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "test.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, method := range map[string]uint16{"stored.fb2": zip.Store, "deflated.fb2": zip.Deflate} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, testBookContent)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestNotModified(t *testing.T) {
+	book := &model.Book{CRC32: 0xdeadbeef, Updated: 1700000000}
+	modified := time.Unix(book.Updated, 0).UTC()
+	for _, tc := range []struct {
+		header, value string
+		expected      bool
+	}{
+		{"If-None-Match", `"deadbeef-epub"`, true},
+		{"If-None-Match", `"0badf00d", W/"deadbeef-epub"`, true},
+		{"If-None-Match", `"deadbeef"`, false},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), true},
+		{"If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), false},
+		{"", "", false},
+	} {
+		r := httptest.NewRequest("GET", "/opds/books?id=1&format=epub", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		got := notModified(w, r, book, "epub")
+		if got != tc.expected {
+			t.Errorf("%s: %s: expecting %v, got: %v", tc.header, tc.value, tc.expected, got)
+		}
+		if got && w.Code != http.StatusNotModified {
+			t.Errorf("%s: %s: expecting status 304, got: %d", tc.header, tc.value, w.Code)
+		}
+		if w.Header().Get("ETag") != `"deadbeef-epub"` {
+			t.Errorf("expecting ETag header, got: %q", w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestSeekBookRange(t *testing.T) {
+	h := testHandler(t)
+	book := &model.Book{ID: 1, CRC32: 1, File: "stored.fb2", Archive: "test.zip", Format: "fb2"}
+	rsc, err := h.seekBook(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsc.Close()
+	if _, ok := rsc.(*sectionCloser); !ok {
+		t.Errorf("expecting stored member to be read in place, got: %T", rsc)
+	}
+	r := httptest.NewRequest("GET", "/opds/books?id=1", nil)
+	r.Header.Set("Range", "bytes=5-9")
+	w := httptest.NewRecorder()
+	serveBook(w, r, book, "", rsc, "stored.fb2", "application/fb2")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expecting status 206, got: %d", w.Code)
+	}
+	if got := w.Body.String(); got != testBookContent[5:10] {
+		t.Errorf("expecting %q, got: %q", testBookContent[5:10], got)
+	}
+	if got := w.Header().Get("Content-Range"); got != fmt.Sprint("bytes 5-9/", len(testBookContent)) {
+		t.Errorf("unexpected Content-Range: %q", got)
+	}
+}
+
+func TestCachedBook(t *testing.T) {
+	h := testHandler(t)
+	book := &model.Book{ID: 2, CRC32: 0xcafe, File: "deflated.fb2", Archive: "test.zip", Format: "fb2"}
+	rsc, err := h.seekBook(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rsc)
+	rsc.Close()
+	if string(data) != testBookContent {
+		t.Errorf("expecting extracted book content, got: %q", data)
+	}
+	name := filepath.Join(h.CFG.Library.CACHE, "2-0000cafe-fb2.fb2")
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("expecting extracted book in the cache: %s", err)
+	}
+	// Cached variant is not written again
+	rsc, err = h.cachedBook(book, "fb2", ".fb2", func(w io.Writer) error {
+		t.Error("cached book is written again")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsc.Close()
+	entries, _ := os.ReadDir(h.CFG.Library.CACHE)
+	if len(entries) != 1 {
+		t.Errorf("expecting the only cached file, got: %d", len(entries))
+	}
+}
+
+func TestZipBook(t *testing.T) {
+	h := testHandler(t)
+	for _, file := range []string{"stored.fb2", "deflated.fb2"} {
+		buf := &bytes.Buffer{}
+		book := &model.Book{ID: 3, File: file, Archive: "test.zip"}
+		if err := h.zipBook(book, "Книга.fb2", buf); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != 1 || zr.File[0].Name != "Книга.fb2" || zr.File[0].Method != zip.Deflate {
+			t.Fatalf("%s: unexpected zip entries: %#v", file, zr.File)
+		}
+		if zr.File[0].Flags&0x800 == 0 {
+			t.Errorf("%s: expecting UTF-8 entry name flag", file)
+		}
+		rc, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !strings.HasPrefix(string(data), "<?xml") {
+			t.Errorf("%s: unexpected zipped book: %q, %v", file, data, err)
+		}
+	}
+}
+
+func TestBadCover(t *testing.T) {
+	h := &Handler{CFG: &config.Config{}}
+	if err := h.resizeCover(io.Discard, []byte("not an image"), 100); !errors.Is(err, errBadCover) {
+		t.Errorf("expecting bad cover error, got: %v", err)
+	}
+	book := &model.Book{ID: 4, CRC32: 0xbad, Cover: "cover.jpg"}
+	if got := coverType(book); got != "image/jpeg" {
+		t.Errorf("expecting image/jpeg cover type, got: %q", got)
+	}
+	badCovers.Store(coverKey(book), true)
+	defer badCovers.Delete(coverKey(book))
+	if got := coverType(book); got != "image/png" {
+		t.Errorf("expecting placeholder cover type image/png, got: %q", got)
+	}
+}
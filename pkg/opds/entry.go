@@ -0,0 +1,125 @@
+package opds
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/fb2"
+	"github.com/vinser/flibgo/pkg/model"
+)
+
+const (
+	// OPDS catalog entry document type
+	EntryType = "application/atom+xml;type=entry;profile=opds-catalog"
+	// OPDS 2.0 publication document type
+	Publication2Type = "application/opds-publication+json"
+)
+
+// EntryDocument is a standalone OPDS catalog entry with complete book metadata
+type EntryDocument struct {
+	XMLName   xml.Name `xml:"entry"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	XmlnsDC   string   `xml:"xmlns:dcterms,attr"`
+	XmlnsOPDS string   `xml:"xmlns:opds,attr"`
+	*Entry
+}
+
+// GET /opds/books?id=""&type=entry - complete entry of the book
+func (h *Handler) bookEntry(w http.ResponseWriter, r *http.Request) {
+	bookId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	book := h.DB.FindBookById(bookId)
+	if book == nil {
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	f := NewFeed(book.Title, "", "")
+	h.feedBookEntries([]*model.Book{book}, f)
+	entry := f.Entry[0]
+	for i := range entry.Link {
+		if entry.Link[i].Type == EntryType {
+			entry.Link[i].Rel = FeedSelfLinkRel
+		}
+	}
+	if d := h.bookDescription(book); d != nil {
+		h.describeEntry(entry, d)
+	}
+	if lang := r.FormValue("lang"); lang != "" {
+		for i := range entry.Link {
+			entry.Link[i].stickLang(lang)
+		}
+	}
+	if isOPDS2(w, r) {
+		writeJSONEntry(w, entry)
+		return
+	}
+	data, err := xml.MarshalIndent(&EntryDocument{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		Entry:     entry,
+	}, "", "  ")
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", EntryType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header+string(data))
+}
+
+// bookDescription reads description of the book file
+func (h *Handler) bookDescription(book *model.Book) *fb2.Description {
+	rc, err := h.openBook(book)
+	if err != nil {
+		h.LOG.E.Print(err)
+		return nil
+	}
+	defer rc.Close()
+	d, err := fb2.NewDescription(rc)
+	if err != nil {
+		h.LOG.E.Printf("failed to read description of book %d: %s\n", book.ID, err)
+		return nil
+	}
+	return d
+}
+
+// describeEntry adds to the entry full annotation, translators and publishing info of the book description
+func (h *Handler) describeEntry(entry *Entry, d *fb2.Description) {
+	fb := &fb2.FB2{TitleInfo: &d.TitleInfo}
+	if annotation := fb.GetAnnotation(); annotation != "" {
+		entry.Content = &Content{Type: FeedHtmlContentType, Content: annotation}
+	}
+	for _, t := range fb.GetTranslators() {
+		entry.Contributors = append(entry.Contributors, Author{Name: t.Name})
+	}
+	pi := d.PublishInfo
+	publisher := []string{}
+	for _, s := range []string{pi.Publisher, pi.City} {
+		if s = strings.TrimSpace(s); s != "" {
+			publisher = append(publisher, s)
+		}
+	}
+	entry.Publisher = strings.Join(publisher, ", ")
+	if year := strings.TrimSpace(pi.Year); year != "" {
+		entry.Issued = year
+	}
+	if isbn := strings.TrimSpace(pi.ISBN); isbn != "" {
+		entry.Identifier = fmt.Sprint("urn:isbn:", isbn)
+	}
+}
+
+func writeJSONEntry(w http.ResponseWriter, entry *Entry) {
+	data, err := json.MarshalIndent(publication2(entry), "", "  ")
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", Publication2Type)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -2,7 +2,11 @@ package opds
 
 import (
 	"encoding/xml"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/vinser/flibgo/pkg/model"
 )
 
 const (
@@ -10,6 +14,7 @@ const (
 	FeedAcquisitionLinkType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
 	FeedNavigationLinkType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
 	FeedSearchLinkType      = "application/opensearchdescription+xml"
+	SuggestionsType         = "application/x-suggestions+json"
 	// Feed link relations
 	FeedStartLinkRel      = "start"
 	FeedSelfLinkRel       = "self"
@@ -19,6 +24,10 @@ const (
 	FeedNextLinkRel       = "next"
 	FeedPrevLinkRel       = "prev"
 	FeedSubsectionLinkRel = "subsection"
+	FeedFacetLinkRel      = "http://opds-spec.org/facet"
+
+	// Genre categories scheme
+	GenreScheme = "http://www.fictionbook.org/genres"
 
 	// Content types
 	FeedTextContentType = "text"
@@ -31,6 +40,7 @@ type Feed struct {
 	XmlnsDC      string   `xml:"xmlns:dcterms,attr,omitempty"`
 	XmlnsOS      string   `xml:"xmlns:opensearch,attr,omitempty"`
 	XmlnsOPDS    string   `xml:"xmlns:opds,attr,omitempty"`
+	XmlnsThr     string   `xml:"xmlns:thr,attr,omitempty"`
 	Title        string   `xml:"title"`
 	ID           string   `xml:"id"`
 	Updated      TimeStr  `xml:"updated"`
@@ -42,23 +52,34 @@ type Feed struct {
 	Logo         string   `xml:"logo,omitempty"`
 	Content      string   `xml:"content,omitempty"`
 	Subtitle     string   `xml:"subtitle,omitempty"`
-	SearchResult uint     `xml:"opensearch:totalResults,omitempty"`
+	SearchResult int64    `xml:"opensearch:totalResults,omitempty"`
+	ItemsPerPage int      `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int64    `xml:"opensearch:startIndex,omitempty"`
 }
 
 type Entry struct {
 	// XMLName   xml.Name `xml:"entry"`
 	// Xmlns     string   `xml:"xmlns,attr,omitempty"`
-	Title     string   `xml:"title"`
-	ID        string   `xml:"id"`
-	Link      []Link   `xml:"link"`
-	Published string   `xml:"published,omitempty"`
-	Updated   TimeStr  `xml:"updated"`
-	Category  string   `xml:"category,omitempty"`
-	Authors   []Author `xml:"author"`
-	Summary   *Summary `xml:"summary"`
-	Content   *Content `xml:"content"`
-	Rights    string   `xml:"rights,omitempty"`
-	Source    string   `xml:"source,omitempty"`
+	Title      string     `xml:"title"`
+	ID         string     `xml:"id"`
+	Link       []Link     `xml:"link"`
+	Published  string     `xml:"published,omitempty"`
+	Updated    TimeStr    `xml:"updated"`
+	Language   string     `xml:"dcterms:language,omitempty"`
+	Issued     string     `xml:"dcterms:issued,omitempty"`
+	Extent     string     `xml:"dcterms:extent,omitempty"`
+	Publisher  string     `xml:"dcterms:publisher,omitempty"`
+	Identifier string     `xml:"dcterms:identifier,omitempty"`
+	Category   []Category `xml:"category"`
+	Authors    []Author   `xml:"author"`
+	// Translators
+	Contributors []Author `xml:"contributor,omitempty"`
+	Summary      *Summary `xml:"summary"`
+	Content      *Content `xml:"content"`
+	Rights       string   `xml:"rights,omitempty"`
+	Source       string   `xml:"source,omitempty"`
+	// Book of acquisition feed entry to build OPDS 2.0 publication from
+	Book *model.Book `xml:"-"`
 }
 
 type Link struct {
@@ -68,6 +89,16 @@ type Link struct {
 	Href   string `xml:"href,attr"`
 	Rel    string `xml:"rel,attr,omitempty"`
 	Length string `xml:"length,attr,omitempty"`
+	// Facet link attributes
+	FacetGroup  string `xml:"opds:facetGroup,attr,omitempty"`
+	ActiveFacet bool   `xml:"opds:activeFacet,attr,omitempty"`
+	Count       int64  `xml:"thr:count,attr,omitempty"`
+}
+
+type Category struct {
+	Term   string `xml:"term,attr"`
+	Label  string `xml:"label,attr,omitempty"`
+	Scheme string `xml:"scheme,attr,omitempty"`
 }
 
 type Author struct {
@@ -93,3 +124,30 @@ type TimeStr string
 func (f *Feed) Time(t time.Time) TimeStr {
 	return TimeStr(t.Format("2006-01-02T15:04:05-07:00"))
 }
+
+// stickLang adds UI language parameter to the catalog links of the feed,
+// so the language chosen by the parameter is kept while browsing
+func (f *Feed) stickLang(lang string) {
+	for i := range f.Link {
+		f.Link[i].stickLang(lang)
+	}
+	for _, e := range f.Entry {
+		if e == nil {
+			continue
+		}
+		for i := range e.Link {
+			e.Link[i].stickLang(lang)
+		}
+	}
+}
+
+func (l *Link) stickLang(lang string) {
+	if !strings.Contains(l.Type, "profile=opds-catalog") && l.Type != FeedSearchLinkType {
+		return
+	}
+	sep := "?"
+	if strings.Contains(l.Href, "?") {
+		sep = "&"
+	}
+	l.Href += sep + "lang=" + url.QueryEscape(lang)
+}
@@ -0,0 +1,102 @@
+package opds
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/vinser/flibgo/pkg/model"
+	"github.com/vinser/flibgo/pkg/normalize"
+)
+
+// maxFileNameLength limits download file name length in bytes, extension included
+const maxFileNameLength = 200
+
+var (
+	rxNamePlaceholder = regexp.MustCompile(`\{(author|series|num|title|year|id|ext)\}`)
+	rxNameSeparators  = regexp.MustCompile(`\s*(-\s*){2,}`)
+	rxNameSpaces      = regexp.MustCompile(`\s+`)
+	rxNameInvalid     = regexp.MustCompile(`[/\\:*?"<>|\x00-\x1f]`)
+)
+
+// bookFileName returns download file name of the book with the extension made by FILENAME template of config.
+// The book file name is used when there is no template
+func (h *Handler) bookFileName(book *model.Book, ext string) string {
+	template := h.CFG.OPDS.FILENAME
+	if template == "" {
+		return strings.TrimSuffix(path.Base(book.File), ".fb2") + "." + ext
+	}
+	h.DB.FillBook(book)
+	name := fileNameOf(template, book, ext)
+	if h.CFG.OPDS.TRANSLIT {
+		name = normalize.ToLatin(name)
+	}
+	return name
+}
+
+// fileNameOf fills the file name template with the book details. Separators of empty details are dropped
+func fileNameOf(template string, book *model.Book, ext string) string {
+	values := map[string]string{
+		"title": book.Title,
+		"year":  book.Year,
+		"id":    fmt.Sprint(book.ID),
+	}
+	if len(book.Authors) > 0 {
+		values["author"] = book.Authors[0].Name
+		if len(book.Authors) > 1 {
+			values["author"] += " et al"
+		}
+	}
+	if book.Serie != nil && book.Serie.Name != "" {
+		values["series"] = book.Serie.Name
+		if book.SerieNum > 0 {
+			values["num"] = fmt.Sprint(book.SerieNum)
+		}
+	}
+	template = strings.TrimSuffix(template, ".{ext}")
+	name := rxNamePlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		if p == "{ext}" {
+			return ext
+		}
+		return rxNameInvalid.ReplaceAllString(values[strings.Trim(p, "{}")], "_")
+	})
+	name = rxNameSpaces.ReplaceAllString(name, " ")
+	name = rxNameSeparators.ReplaceAllString(name, " - ")
+	name = strings.Trim(name, " -._")
+	if name == "" {
+		name = fmt.Sprint(book.ID)
+	}
+	if max := maxFileNameLength - len(ext) - 1; len(name) > max {
+		name = strings.TrimSpace(strings.ToValidUTF8(name[:max], ""))
+	}
+	return name + "." + ext
+}
+
+// contentDisposition returns attachment Content-Disposition header value with ASCII file name
+// and UTF-8 one (RFC 6266, RFC 5987)
+func contentDisposition(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, normalize.ToLatin(name))
+	if ascii == name {
+		return fmt.Sprintf(`attachment; filename="%s"`, ascii)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, extValue(name))
+}
+
+// extValue percent-encodes the value except RFC 5987 attr-chars
+func extValue(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			sb.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", b)
+	}
+	return sb.String()
+}
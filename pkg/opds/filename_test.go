@@ -0,0 +1,41 @@
+package opds
+
+import (
+	"testing"
+
+	"github.com/vinser/flibgo/pkg/model"
+)
+
+func TestFileNameOf(t *testing.T) {
+	template := "{author} - {series} {num} - {title}.{ext}"
+	book := &model.Book{
+		ID:       123456,
+		Title:    "Война и мир: том 1",
+		Authors:  []*model.Author{{Name: "Лев Толстой"}},
+		Serie:    &model.Serie{Name: "Классика"},
+		SerieNum: 2,
+	}
+	for _, tc := range []struct {
+		book     *model.Book
+		expected string
+	}{
+		{book, "Лев Толстой - Классика 2 - Война и мир_ том 1.fb2"},
+		{&model.Book{ID: 1, Title: "Title", Serie: &model.Serie{}}, "Title.fb2"},
+		{&model.Book{ID: 7}, "7.fb2"},
+	} {
+		if got := fileNameOf(template, tc.book, "fb2"); got != tc.expected {
+			t.Errorf("expecting %q, got: %q", tc.expected, got)
+		}
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	for name, expected := range map[string]string{
+		"123456.fb2":            `attachment; filename="123456.fb2"`,
+		"Лев Толстой - Мир.fb2": `attachment; filename="Lev Tolstoy - Mir.fb2"; filename*=UTF-8''%D0%9B%D0%B5%D0%B2%20%D0%A2%D0%BE%D0%BB%D1%81%D1%82%D0%BE%D0%B9%20-%20%D0%9C%D0%B8%D1%80.fb2`,
+	} {
+		if got := contentDisposition(name); got != expected {
+			t.Errorf("expecting %q, got: %q", expected, got)
+		}
+	}
+}
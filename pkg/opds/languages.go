@@ -0,0 +1,130 @@
+package opds
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vinser/flibgo/pkg/database"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// languages
+func (h *Handler) languages(w http.ResponseWriter, r *http.Request) {
+	switch {
+	default:
+		h.listLanguages(w, r)
+		h.LOG.D.Println("ListLanguages")
+	case r.FormValue("code") != "" && r.FormValue("books") == "":
+		h.languageAnthology(w, r)
+		h.LOG.D.Println("LanguageAnthology")
+	case r.FormValue("code") != "":
+		h.languageBooks(w, r)
+		h.LOG.D.Println("LanguageBooks")
+	}
+}
+
+// GET /opds/languages - all book languages
+func (h *Handler) listLanguages(w http.ResponseWriter, r *http.Request) {
+	selfHref := "/opds/languages"
+	f := NewFeed(h.P.Sprintf("Languages"), "", selfHref)
+	f.Entry = []*Entry{}
+	for _, l := range h.DB.ListLanguages() {
+		href := "/opds/languages?code=" + url.QueryEscape(l.Code)
+		content := h.P.Sprintf("Total books - %d", l.Count)
+		if name := h.languageName(l.Code); !strings.EqualFold(name, l.Name) {
+			content = l.Name + ", " + content
+		}
+		entry := &Entry{
+			Title:   h.languageName(l.Code),
+			ID:      href,
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: href, Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: content,
+			},
+		}
+		f.Entry = append(f.Entry, entry)
+	}
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// GET /opds/languages?code="" - choose language books by authors, genres or titles
+func (h *Handler) languageAnthology(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	selfHref := "/opds/languages?code=" + url.QueryEscape(code)
+	f := NewFeed(h.languageName(code), "", selfHref)
+	f.Entry = []*Entry{
+		{
+			Title:   h.P.Sprintf("Authors"),
+			ID:      withLanguage("/opds/authors", code),
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: withLanguage("/opds/authors", code), Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Choose an author of a book"),
+			},
+		},
+		{
+			Title:   h.P.Sprintf("Genres"),
+			ID:      withLanguage("/opds/genres", code),
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: withLanguage("/opds/genres", code), Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Choose a genre of a book"),
+			},
+		},
+		{
+			Title:   h.P.Sprintf("Titles"),
+			ID:      selfHref + "&books=all",
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: selfHref + "&books=all", Type: FeedAcquisitionLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("List books alphabetically"),
+			},
+		},
+	}
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// GET /opds/languages?code=""&books=all - all language books
+func (h *Handler) languageBooks(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	listHref := fmt.Sprint("/opds/languages?code=", url.QueryEscape(code), "&books=all")
+	f := NewFeed(h.languageName(code), "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.LanguageBookList(code), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// languageName returns the language name in the catalog language
+func (h *Handler) languageName(code string) string {
+	name := display.Tags(language.Make(h.lang)).Name(language.Make(code))
+	if name == "" {
+		name = database.Endonym(code)
+	}
+	return capitalize(name)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
@@ -3,11 +3,16 @@ package opds
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"image"
+	"image/gif"
 	"image/jpeg"
+	"image/png"
 	"io"
 	"mime"
 	"net/http"
@@ -16,6 +21,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
@@ -24,38 +30,67 @@ import (
 	"github.com/vinser/flibgo/pkg/fb2"
 	"github.com/vinser/flibgo/pkg/genres"
 	"github.com/vinser/flibgo/pkg/model"
+	"github.com/vinser/flibgo/pkg/placeholder"
 	"github.com/vinser/flibgo/pkg/rlog"
+	"github.com/vinser/flibgo/pkg/search"
 
 	"github.com/nfnt/resize"
+	"golang.org/x/text/language"
 	"golang.org/x/text/message"
-
-	_ "image/gif"
-	_ "image/png"
 )
 
+const suggestionsLimit = 10
+
 type Handler struct {
 	CFG *config.Config
 	DB  *database.DB
 	GT  *genres.GenresTree
 	P   *message.Printer
 	LOG *rlog.Log
+	LM  language.Matcher
+	SI  *search.SuggestIndex
+	// UI language of the request
+	lang string
+	// book formats preferred by the request device
+	formats []string
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.LOG.I.Println(commentURL("Router", r))
 	// switch r.URL.Path {
-	switch strings.ReplaceAll(r.URL.Path, "//", "/") { // compensate PocketBook Reader search query error
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		h.admin(w, r)
+		return
+	}
+	urlPath := strings.ReplaceAll(r.URL.Path, "//", "/") // compensate PocketBook Reader search query error
+	// OPDS 2.0 catalog tree is served by the same handlers as OPDS 1.2 one
+	if urlPath == "/opds2" || strings.HasPrefix(urlPath, "/opds2/") {
+		urlPath = "/opds" + strings.TrimPrefix(urlPath, "/opds2")
+		r = r.WithContext(context.WithValue(r.Context(), opds2Key, true))
+	}
+	h = h.localize(r)
+	h.formats = h.deviceFormats(r)
+	switch urlPath {
 	case "/opds":
 		h.root(w, r)
 	case "/opds/search":
 		h.serach(w, r)
+	case "/opds/opensearch":
+		h.openSearch(w, r)
+	case "/opds/suggest":
+		h.suggest(w, r)
 	case "/opds/authors":
 		h.authors(w, r)
 	case "/opds/genres":
 		h.genres(w, r)
 	case "/opds/series":
 		h.series(w, r)
+	case "/opds/new":
+		h.newArrivals(w, r)
+	case "/opds/new/atom":
+		h.announceArrivals(w, r)
 	case "/opds/languages":
+		h.languages(w, r)
 	case "/opds/books":
 		h.books(w, r)
 	case "/opds/covers":
@@ -67,6 +102,29 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// localize returns the handler copy with the UI language negotiated from "lang" parameter
+// or Accept-Language header and the locales available
+func (h *Handler) localize(r *http.Request) *Handler {
+	lh := *h
+	lh.lang = h.CFG.Language.DEFAULT
+	if h.LM == nil {
+		return &lh
+	}
+	tag, _ := language.MatchStrings(h.LM, r.FormValue("lang"), r.Header.Get("Accept-Language"))
+	base, _ := tag.Base()
+	lh.lang = base.String()
+	lh.P = message.NewPrinter(language.Make(lh.lang))
+	return &lh
+}
+
+// genreName returns the genre name in the UI language or in the default one
+func (h *Handler) genreName(code string) string {
+	if name := h.GT.GenreName(code, h.lang); name != "" {
+		return name
+	}
+	return h.GT.GenreName(code, h.CFG.Language.DEFAULT)
+}
+
 // Root
 func (h *Handler) root(w http.ResponseWriter, r *http.Request) {
 	selfHref := "/opds"
@@ -96,6 +154,42 @@ func (h *Handler) root(w http.ResponseWriter, r *http.Request) {
 				Content: h.P.Sprintf("Choose a genre of a book"),
 			},
 		},
+		{
+			Title:   h.P.Sprintf("New this week"),
+			ID:      "new-week",
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: "/opds/new?days=7", Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Books added during the last week"),
+			},
+		},
+		{
+			Title:   h.P.Sprintf("New this month"),
+			ID:      "new-month",
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: "/opds/new?days=30", Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Books added during the last month"),
+			},
+		},
+		{
+			Title:   h.P.Sprintf("All recent"),
+			ID:      "new-all",
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: "/opds/new", Type: FeedAcquisitionLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("All books, newest first"),
+			},
+		},
 		{
 			Title:   h.P.Sprintf("Book Series"),
 			ID:      "series",
@@ -108,9 +202,21 @@ func (h *Handler) root(w http.ResponseWriter, r *http.Request) {
 				Content: h.P.Sprintf("Choose a serie of a book"),
 			},
 		},
+		{
+			Title:   h.P.Sprintf("Book Languages"),
+			ID:      "languages",
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: "/opds/languages", Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Choose a language of a book"),
+			},
+		},
 	}
-	//
-	writeFeed(w, http.StatusOK, *f)
+	f.Link = append(f.Link, Link{Rel: "related", Href: "/opds/new/atom", Type: AtomFeedType, Title: h.P.Sprintf("New books")})
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 // Search
@@ -118,40 +224,35 @@ func (h *Handler) root(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) serach(w http.ResponseWriter, r *http.Request) {
 	h.LOG.D.Println(commentURL("Search", r))
 
-	books := []*model.Book{}
-	authors := []*model.Author{}
-	selfHref := ""
-	queryString := ""
-
+	sq := h.searchQuery(r)
 	switch {
-	case r.FormValue("q") != "":
-		queryString = r.FormValue("q")
-		if utf8.RuneCountInString(queryString) < 3 {
-			return
-		}
-		books = h.DB.SearchBooks(queryString)
-		authors = h.DB.SearchAuthors(queryString)
-	case r.FormValue("book") != "":
-		queryString = r.FormValue("book")
-		books = h.DB.SearchBooks(queryString)
-	case r.FormValue("author") != "":
-		queryString = r.FormValue("author")
-		authors = h.DB.SearchAuthors(queryString)
-	}
-
-	bc := len(books)
-	ac := len(authors)
+	case sq.IsEmpty():
+		return
+	case sq.IsAuthorOnly():
+		h.searchAuthors(w, r, sq.Author)
+		return
+	case sq.IsFielded():
+		h.searchBooks(w, r, sq)
+		return
+	}
+
+	queryString := sq.Text
+	if utf8.RuneCountInString(queryString) < 3 {
+		return
+	}
+	bc := h.DB.QueryBookList(&search.Query{Title: queryString}).Count()
+	ac := len(h.DB.SearchAuthors(queryString))
 	switch {
 	case (ac != 0 && bc != 0):
-		selfHref = "/opds/search?q={searchTerms}"
+		selfHref := "/opds/search?q=" + url.QueryEscape(queryString)
 		f := NewFeed(h.P.Sprintf("Choose from the found ones"), "", selfHref)
 		f.Entry = []*Entry{
 			{
 				Title:   h.P.Sprintf("Titles"),
-				ID:      fmt.Sprint("/opds/search?book=", queryString),
+				ID:      fmt.Sprint("/opds/search?book=", url.QueryEscape(queryString)),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/search?book=", queryString), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/search?book=", url.QueryEscape(queryString)), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -160,10 +261,10 @@ func (h *Handler) serach(w http.ResponseWriter, r *http.Request) {
 			},
 			{
 				Title:   h.P.Sprintf("Authors"),
-				ID:      fmt.Sprint("/opds/search?author=", queryString),
+				ID:      fmt.Sprint("/opds/search?author=", url.QueryEscape(queryString)),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/search?author=", queryString), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/search?author=", url.QueryEscape(queryString)), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -171,32 +272,108 @@ func (h *Handler) serach(w http.ResponseWriter, r *http.Request) {
 				},
 			},
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	case ac == 0 && bc != 0: // show books
-		page, err := strconv.Atoi(r.FormValue("page"))
-		if err != nil {
-			page = 1
-		}
-		offset := (page - 1) * h.CFG.OPDS.PAGE_SIZE
-		books := h.DB.PageSearchedBooks(queryString, h.CFG.OPDS.PAGE_SIZE+1, offset)
-		selfHref = fmt.Sprintf("/opds/search?book=%s&page=%d", queryString, page)
-		f := NewFeed(h.GT.GenreName(queryString, h.CFG.Language.DEFAULT), "", selfHref)
-		if len(books) > h.CFG.OPDS.PAGE_SIZE {
-			nextRef := fmt.Sprintf("/opds/search?book=%s&page=%d", queryString, page+1)
-			nextLink := &Link{Rel: FeedNextLinkRel, Href: nextRef, Type: FeedNavigationLinkType}
-			f.Link = append(f.Link, *nextLink)
-			books = books[:h.CFG.OPDS.PAGE_SIZE-1]
-		}
-
-		h.feedBookEntries(books, f)
-		writeFeed(w, http.StatusOK, *f)
+		h.searchBooks(w, r, &search.Query{Title: queryString})
 	case ac != 0 && bc == 0: // show authors
-		h.listAuthors(w, r)
+		h.searchAuthors(w, r, queryString)
 	default:
 		return
 	}
 }
 
+// GET /opds/suggest?q="" - OpenSearch suggestions of authors, series and titles
+func (h *Handler) suggest(w http.ResponseWriter, r *http.Request) {
+	queryString := r.FormValue("q")
+	completions := []string{}
+	descriptions := []string{}
+	urls := []string{}
+	for _, s := range h.SI.Suggest(queryString, suggestionsLimit) {
+		completions = append(completions, s.Label)
+		switch s.Kind {
+		case search.AuthorSuggestion:
+			descriptions = append(descriptions, h.P.Sprintf("Author, total books - %d", s.Weight))
+			urls = append(urls, fmt.Sprint("/opds/authors?id=", s.ID))
+		case search.SerieSuggestion:
+			descriptions = append(descriptions, h.P.Sprintf("Serie, total books - %d", s.Weight))
+			urls = append(urls, fmt.Sprint("/opds/series?id=", s.ID))
+		default:
+			descriptions = append(descriptions, h.P.Sprintf("Title"))
+			urls = append(urls, fmt.Sprint("/opds/books?id=", s.ID, "&type=entry"))
+		}
+	}
+	data, err := json.Marshal([]interface{}{queryString, completions, descriptions, urls})
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", SuggestionsType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// searchQuery combines fielded query from "q" with named search parameters
+func (h *Handler) searchQuery(r *http.Request) *search.Query {
+	sq := search.ParseQuery(r.FormValue("q"))
+	sq.Set("title", r.FormValue("book"))
+	for _, field := range []string{"author", "title", "series", "language", "year", "genre"} {
+		sq.Set(field, r.FormValue(field))
+	}
+	// Genre bunch stands for all its subgenres
+	genres := []string{}
+	for _, g := range sq.Genres {
+		subgenres := h.GT.ListSubGenres(g)
+		if len(subgenres) == 0 {
+			genres = append(genres, g)
+		}
+		for _, sg := range subgenres {
+			genres = append(genres, sg.Value)
+		}
+	}
+	sq.Genres = genres
+	return sq
+}
+
+func (h *Handler) searchAuthors(w http.ResponseWriter, r *http.Request, pattern string) {
+	authors := h.DB.SearchAuthors(pattern)
+	selfHref := "/opds/search?author=" + url.QueryEscape(pattern)
+	f := NewFeed(h.P.Sprintf("Authors"), "", selfHref)
+	for _, a := range authors {
+		entry := &Entry{
+			Title:   a.Sort,
+			ID:      "/opds/authors?id=" + fmt.Sprint(a.ID),
+			Updated: f.Time(time.Now()),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: "/opds/authors?id=" + fmt.Sprint(a.ID), Type: FeedNavigationLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Total books - %d", a.Count),
+			},
+		}
+		f.Entry = append(f.Entry, entry)
+	}
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+func (h *Handler) searchBooks(w http.ResponseWriter, r *http.Request, sq *search.Query) {
+	// Language parameter is the list facet, so it is left out of the list query. The facet narrows
+	// lang: terms of the query down, no books are found when it is none of them
+	if language := strings.ToLower(r.FormValue(database.FacetLanguage)); language != "" {
+		for i := len(sq.Languages) - 1; i >= 0; i-- {
+			if sq.Languages[i] == language {
+				sq.Languages = append(sq.Languages[:i], sq.Languages[i+1:]...)
+				break
+			}
+		}
+	}
+	queryString := sq.String()
+	listHref := "/opds/search?q=" + url.QueryEscape(queryString)
+	f := NewFeed(queryString, "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.QueryBookList(sq), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
 // authors
 func (h *Handler) authors(w http.ResponseWriter, r *http.Request) {
 	switch {
@@ -219,7 +396,8 @@ func (h *Handler) authors(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) listAuthors(w http.ResponseWriter, r *http.Request) {
 	// prefix, err := url.QueryUnescape(r.FormValue("author"))
 	prefix := r.FormValue("author")
-	authors := h.DB.ListAuthors(prefix, h.CFG.Language.DEFAULT)
+	bookLanguage := r.FormValue(database.FacetLanguage)
+	authors := h.DB.ListAuthors(prefix, h.lang, bookLanguage)
 	if len(authors) == 0 {
 		return
 	}
@@ -234,18 +412,19 @@ func (h *Handler) listAuthors(w http.ResponseWriter, r *http.Request) {
 	} else {
 		selfHref = "/opds/authors?author=" + url.QueryEscape(prefix)
 	}
+	selfHref = withLanguage(selfHref, bookLanguage)
 
 	f := NewFeed(h.P.Sprintf("Authors"), "", selfHref)
 	switch {
 	case totalAuthors <= h.CFG.OPDS.PAGE_SIZE:
-		authors = h.DB.ListAuthorWithTotals(prefix)
+		authors = h.DB.ListAuthorWithTotals(prefix, bookLanguage)
 		for i := range authors {
 			entry := &Entry{
 				Title:   authors[i].Sort,
 				ID:      "/opds/authors?author=" + authors[i].Sort,
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: "/opds/authors?id=" + fmt.Sprint(authors[i].ID), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage("/opds/authors?id="+fmt.Sprint(authors[i].ID), bookLanguage), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -254,7 +433,7 @@ func (h *Handler) listAuthors(w http.ResponseWriter, r *http.Request) {
 			}
 			f.Entry = append(f.Entry, entry)
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	default:
 		for i := range authors {
 			entry := &Entry{
@@ -262,7 +441,7 @@ func (h *Handler) listAuthors(w http.ResponseWriter, r *http.Request) {
 				ID:      "/opds/authors?author=" + authors[i].Sort,
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: "/opds/authors?author=" + url.QueryEscape(authors[i].Sort), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage("/opds/authors?author="+url.QueryEscape(authors[i].Sort), bookLanguage), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -271,7 +450,7 @@ func (h *Handler) listAuthors(w http.ResponseWriter, r *http.Request) {
 			}
 			f.Entry = append(f.Entry, entry)
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	}
 }
 
@@ -289,7 +468,7 @@ func (h *Handler) authorAnthology(w http.ResponseWriter, r *http.Request) {
 				ID:      fmt.Sprint("/opds/authors?id=", authorId, "&anthology=alphabet"),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/authors?id=", authorId, "&anthology=alphabet"), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage(fmt.Sprint("/opds/authors?id=", authorId, "&anthology=alphabet"), r.FormValue(database.FacetLanguage)), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -301,7 +480,7 @@ func (h *Handler) authorAnthology(w http.ResponseWriter, r *http.Request) {
 				ID:      fmt.Sprint("/opds/authors?id=", authorId, "&anthology=series"),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/authors?id=", authorId, "&anthology=series"), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage(fmt.Sprint("/opds/authors?id=", authorId, "&anthology=series"), r.FormValue(database.FacetLanguage)), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Type:    FeedTextContentType,
@@ -309,7 +488,7 @@ func (h *Handler) authorAnthology(w http.ResponseWriter, r *http.Request) {
 				},
 			},
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	} else { // Author doesn't have book series
 		h.authorBooks(w, r)
 	}
@@ -329,36 +508,25 @@ func (h *Handler) authorAnthologySeries(w http.ResponseWriter, r *http.Request)
 			ID:      fmt.Sprint("/opds/authors?id=", authorId, "&serie=", serie.ID),
 			Updated: f.Time(time.Now()),
 			Link: []Link{
-				{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/authors?id=", authorId, "&serie=", serie.ID), Type: FeedNavigationLinkType},
+				{Rel: FeedSubsectionLinkRel, Href: withLanguage(fmt.Sprint("/opds/authors?id=", authorId, "&serie=", serie.ID), r.FormValue(database.FacetLanguage)), Type: FeedNavigationLinkType},
 			},
 		}
 		f.Entry = append(f.Entry, entry)
 	}
-	writeFeed(w, http.StatusOK, *f)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 func (h *Handler) authorBooks(w http.ResponseWriter, r *http.Request) {
 	authorId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
 	serieId, _ := strconv.ParseInt(r.FormValue("serie"), 10, 64)
 	author := h.DB.AuthorByID(authorId)
-	page, err := strconv.Atoi(r.FormValue("page"))
-	if err != nil {
-		page = 1
-	}
-	offset := (page - 1) * h.CFG.OPDS.PAGE_SIZE
-
-	books := h.DB.ListAuthorBooks(authorId, serieId, h.CFG.OPDS.PAGE_SIZE+1, offset)
-	selfHref := fmt.Sprintf("/opds/authors?id=%d&anthology=alphabet&page=%d", authorId, page)
-	f := NewFeed(author.Name, "", selfHref)
-	if len(books) > h.CFG.OPDS.PAGE_SIZE {
-		nextRef := fmt.Sprintf("/opds/authors?id=%d&anthology=alphabet&page=%d", authorId, page+1)
-		nextLink := &Link{Rel: FeedNextLinkRel, Href: nextRef, Type: FeedNavigationLinkType}
-		f.Link = append(f.Link, *nextLink)
-		books = books[:h.CFG.OPDS.PAGE_SIZE-1]
+	listHref := fmt.Sprintf("/opds/authors?id=%d&anthology=alphabet", authorId)
+	if serieId != 0 {
+		listHref = fmt.Sprintf("/opds/authors?id=%d&serie=%d", authorId, serieId)
 	}
-
-	h.feedBookEntries(books, f)
-	writeFeed(w, http.StatusOK, *f)
+	f := NewFeed(author.Name, "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.AuthorBookList(authorId, serieId), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 // genres
@@ -377,57 +545,67 @@ func (h *Handler) genres(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) listGenres(w http.ResponseWriter, r *http.Request) {
-	selfHref := "/opds/genres"
+	bookLanguage := r.FormValue(database.FacetLanguage)
+	selfHref := withLanguage("/opds/genres", bookLanguage)
 	f := NewFeed(h.P.Sprintf("Genres"), "", selfHref)
 	f.Entry = []*Entry{}
-	var entry *Entry
 	genres := h.GT.ListGenres()
-	title := ""
-	content := ""
 	for _, genre := range genres {
+		title, content := "", ""
 		for _, gd := range genre.Descriptions {
-			if gd.Lang == h.CFG.Language.DEFAULT {
+			// UI language description goes over the default language one
+			if gd.Lang == h.lang || (title == "" && gd.Lang == h.CFG.Language.DEFAULT) {
 				title = gd.Title
 				content = gd.Detailed
+			}
+			if gd.Lang == h.lang {
 				break
 			}
 		}
 		if title != "" {
-			entry = &Entry{
+			entry := &Entry{
 				Title:   title,
 				ID:      fmt.Sprint("/opds/genres?bunch=", genre.Value),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/genres?bunch=", genre.Value), Type: FeedNavigationLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage(fmt.Sprint("/opds/genres?bunch=", genre.Value), bookLanguage), Type: FeedNavigationLinkType},
 				},
 				Content: &Content{
 					Content: content,
 					Type:    FeedTextContentType,
 				},
 			}
+			f.Entry = append(f.Entry, entry)
 		}
-		f.Entry = append(f.Entry, entry)
 	}
-	writeFeed(w, http.StatusOK, *f)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 func (h *Handler) listSubgenres(w http.ResponseWriter, r *http.Request) {
 	bunch := r.FormValue("bunch")
-	selfHref := fmt.Sprint("/opds/genres?bunch=", bunch)
+	bookLanguage := r.FormValue(database.FacetLanguage)
+	selfHref := withLanguage(fmt.Sprint("/opds/genres?bunch=", bunch), bookLanguage)
 	f := NewFeed(h.P.Sprintf("Genres"), "", selfHref)
 	f.Entry = []*Entry{}
 	var entry *Entry
 	subgenres := h.GT.ListSubGenres(bunch)
 	for _, sg := range subgenres {
-		title := h.GT.SubgenreName(&sg, h.CFG.Language.DEFAULT)
-		gbc := h.DB.CountGenreBooks(sg.Value)
+		title := h.GT.SubgenreName(&sg, h.lang)
+		if title == "" {
+			title = h.GT.SubgenreName(&sg, h.CFG.Language.DEFAULT)
+		}
+		gbc := h.DB.CountGenreBooks(sg.Value, bookLanguage)
+		// Genres without books of the language are left out
+		if bookLanguage != "" && gbc == 0 {
+			continue
+		}
 		if title != "" {
 			entry = &Entry{
 				Title:   title,
 				ID:      fmt.Sprint("/opds/genres?code=", sg.Value),
 				Updated: f.Time(time.Now()),
 				Link: []Link{
-					{Rel: FeedSubsectionLinkRel, Href: fmt.Sprint("/opds/genres?code=", sg.Value), Type: FeedAcquisitionLinkType},
+					{Rel: FeedSubsectionLinkRel, Href: withLanguage(fmt.Sprint("/opds/genres?code=", sg.Value), bookLanguage), Type: FeedAcquisitionLinkType},
 				},
 				Content: &Content{
 					Content: h.P.Sprintf("Found titles - %d", gbc),
@@ -437,28 +615,15 @@ func (h *Handler) listSubgenres(w http.ResponseWriter, r *http.Request) {
 		}
 		f.Entry = append(f.Entry, entry)
 	}
-	writeFeed(w, http.StatusOK, *f)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 func (h *Handler) genreBooks(w http.ResponseWriter, r *http.Request) {
 	genreCode := r.FormValue("code")
-	page, err := strconv.Atoi(r.FormValue("page"))
-	if err != nil {
-		page = 1
-	}
-	offset := (page - 1) * h.CFG.OPDS.PAGE_SIZE
-	books := h.DB.ListGenreBooks(genreCode, h.CFG.OPDS.PAGE_SIZE+1, offset)
-	selfHref := fmt.Sprintf("/opds/genres?code=%s&page=%d", genreCode, page)
-	f := NewFeed(h.GT.GenreName(genreCode, h.CFG.Language.DEFAULT), "", selfHref)
-	if len(books) > h.CFG.OPDS.PAGE_SIZE {
-		nextRef := fmt.Sprintf("/opds/genres?code=%s&page=%d", genreCode, page+1)
-		nextLink := &Link{Rel: FeedNextLinkRel, Href: nextRef, Type: FeedNavigationLinkType}
-		f.Link = append(f.Link, *nextLink)
-		books = books[:h.CFG.OPDS.PAGE_SIZE-1]
-	}
-
-	h.feedBookEntries(books, f)
-	writeFeed(w, http.StatusOK, *f)
+	listHref := "/opds/genres?code=" + url.QueryEscape(genreCode)
+	f := NewFeed(h.genreName(genreCode), "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.GenreBookList(genreCode), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 // series
@@ -475,7 +640,7 @@ func (h *Handler) series(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) listSeries(w http.ResponseWriter, r *http.Request) {
 	prefix := r.FormValue("serie")
-	series := h.DB.ListSeries(prefix, h.CFG.Language.DEFAULT)
+	series := h.DB.ListSeries(prefix, h.lang)
 	if len(series) == 0 {
 		return
 	}
@@ -510,7 +675,7 @@ func (h *Handler) listSeries(w http.ResponseWriter, r *http.Request) {
 			}
 			f.Entry = append(f.Entry, entry)
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	default:
 		for _, serie := range series {
 			entry := &Entry{
@@ -527,85 +692,261 @@ func (h *Handler) listSeries(w http.ResponseWriter, r *http.Request) {
 			}
 			f.Entry = append(f.Entry, entry)
 		}
-		writeFeed(w, http.StatusOK, *f)
+		writeFeed(w, r, http.StatusOK, *f)
 	}
 }
 
 func (h *Handler) serieBooks(w http.ResponseWriter, r *http.Request) {
 	serieId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
 	serie := h.DB.SerieByID(serieId)
-	page, err := strconv.Atoi(r.FormValue("page"))
-	if err != nil {
-		page = 1
-	}
-	offset := (page - 1) * h.CFG.OPDS.PAGE_SIZE
-
-	books := h.DB.ListSerieBooks(serieId, h.CFG.OPDS.PAGE_SIZE+1, offset)
-	selfHref := fmt.Sprintf("/opds/series?id=%d&page=%d", serieId, page)
-	f := NewFeed(serie.Name, "", selfHref)
-	if len(books) > h.CFG.OPDS.PAGE_SIZE {
-		nextRef := fmt.Sprintf("/opds/series?id=%d&page=%d", serieId, page+1)
-		nextLink := &Link{Rel: FeedNextLinkRel, Href: nextRef, Type: FeedNavigationLinkType}
-		f.Link = append(f.Link, *nextLink)
-		books = books[:h.CFG.OPDS.PAGE_SIZE-1]
-	}
-
-	h.feedBookEntries(books, f)
-	writeFeed(w, http.StatusOK, *f)
+	listHref := fmt.Sprint("/opds/series?id=", serieId)
+	f := NewFeed(serie.Name, "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.SerieBookList(serieId), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
 }
 
 // Books
 func (h *Handler) books(w http.ResponseWriter, r *http.Request) {
 	switch {
 	default:
+	case r.FormValue("id") != "" && r.FormValue("type") == "entry":
+		h.bookEntry(w, r)
+		h.LOG.D.Println("BookEntry")
+	case r.FormValue("id") != "" && h.formatKey(r) == "fb2.zip":
+		h.unloadZipped(w, r)
+		h.LOG.D.Println("UnloadZipped")
+	case r.FormValue("id") != "" && h.formatKey(r) != "" && h.formatKey(r) != "fb2":
+		h.unloadConverted(w, r)
+		h.LOG.D.Println("UnloadConverted")
 	case r.FormValue("id") != "":
 		h.unloadBook(w, r)
 		h.LOG.D.Println("UnloadBook")
 	}
 }
 
+// pageBooks adds to the feed the book list page given by "after", "before" or "last" parameter,
+// the links to the first, previous, next and last pages, facet links and OpenSearch page counts
+func (h *Handler) pageBooks(f *Feed, r *http.Request, bl *database.BookList, listHref string) {
+	defaultOrder := bl.OrderedBy()
+	bl.OrderBy(r.FormValue("sort"))
+	for _, facet := range []string{database.FacetLanguage, database.FacetFormat} {
+		bl.Filter(facet, r.FormValue(facet))
+	}
+	h.facetLinks(f, r, bl, listHref, defaultOrder)
+	listHref = facetHref(listHref, r)
+	limit := h.CFG.OPDS.PAGE_SIZE
+	// Books are counted for the first and the last pages only, the following pages get
+	// the position and the total from the cursor
+	var page *database.Page
+	var total, before int64 = 0, -1
+	switch {
+	case r.FormValue("before") != "":
+		c := database.ParseCursor(r.FormValue("before"))
+		page = bl.Before(c, limit)
+		switch {
+		case c != nil && len(page.Books) < limit:
+			before, total = 0, c.Total
+		case c != nil && c.Pos > 0:
+			before, total = c.Pos-1-int64(len(page.Books)), c.Total
+		}
+	case r.FormValue("last") != "":
+		total = bl.Count()
+		// Last page is aligned with the pages from the first one
+		lastLimit := int(total % int64(limit))
+		if lastLimit == 0 {
+			lastLimit = limit
+		}
+		page = bl.Before(nil, lastLimit)
+		before = total - int64(len(page.Books))
+	default:
+		c := database.ParseCursor(r.FormValue("after"))
+		page = bl.After(c, limit)
+		switch {
+		case c == nil:
+			before = 0
+		case c.Pos > 0:
+			before, total = c.Pos, c.Total
+		}
+	}
+	if total == 0 {
+		total = bl.Count()
+	}
+	if before < 0 && page.First != nil {
+		before = bl.CountBefore(page.First)
+	}
+	if before < 0 {
+		before = 0
+	}
+	// Total of the cursor is stale when books were added or removed since the list was counted
+	if n := before + int64(len(page.Books)); total < n || len(page.Books) < limit && r.FormValue("before") == "" {
+		total = n
+	}
+	if page.First != nil {
+		page.First.Pos, page.First.Total = before+1, total
+		page.Last.Pos, page.Last.Total = before+int64(len(page.Books)), total
+	}
+	f.SearchResult = total
+	f.ItemsPerPage = limit
+	f.StartIndex = before + 1
+	if total > int64(limit) {
+		f.Link = append(f.Link, Link{Rel: FeedFirstLinkRel, Href: listHref, Type: FeedAcquisitionLinkType})
+	}
+	if before > 0 {
+		f.Link = append(f.Link, Link{Rel: FeedPrevLinkRel, Href: listHref + "&before=" + page.First.Token(), Type: FeedAcquisitionLinkType})
+	}
+	if page.Last != nil && before+int64(len(page.Books)) < total {
+		f.Link = append(f.Link, Link{Rel: FeedNextLinkRel, Href: listHref + "&after=" + page.Last.Token(), Type: FeedAcquisitionLinkType})
+	}
+	if total > int64(limit) {
+		f.Link = append(f.Link, Link{Rel: FeedLastLinkRel, Href: listHref + "&last=1", Type: FeedAcquisitionLinkType})
+	}
+	h.feedBookEntries(page.Books, f)
+}
+
+// facetLinks adds to the feed the links to sort the book list and to filter it by language and format
+func (h *Handler) facetLinks(f *Feed, r *http.Request, bl *database.BookList, listHref, defaultOrder string) {
+	orders := []string{database.OrderTitle, database.OrderYear, database.OrderAdded}
+	if defaultOrder == database.OrderSerie {
+		orders = append([]string{database.OrderSerie}, orders...)
+	}
+	group := h.P.Sprintf("Sort")
+	for _, o := range orders {
+		f.Link = append(f.Link, Link{
+			Rel:         FeedFacetLinkRel,
+			Href:        facetLink(listHref, r, "sort", o),
+			Type:        FeedAcquisitionLinkType,
+			Title:       h.orderTitle(o),
+			FacetGroup:  group,
+			ActiveFacet: o == bl.OrderedBy(),
+		})
+	}
+	for _, facet := range []string{database.FacetLanguage, database.FacetFormat} {
+		selected := r.FormValue(facet)
+		values := bl.Facets(facet)
+		// Nothing to choose from
+		if len(values) < 2 && selected == "" {
+			continue
+		}
+		group, all := h.P.Sprintf("Language"), h.P.Sprintf("All languages")
+		if facet == database.FacetFormat {
+			group, all = h.P.Sprintf("Format"), h.P.Sprintf("All formats")
+		}
+		f.Link = append(f.Link, Link{
+			Rel:         FeedFacetLinkRel,
+			Href:        facetLink(listHref, r, facet, ""),
+			Type:        FeedAcquisitionLinkType,
+			Title:       all,
+			FacetGroup:  group,
+			ActiveFacet: selected == "",
+		})
+		for _, v := range values {
+			if facet == database.FacetLanguage {
+				v.Title = h.languageName(v.Value)
+			}
+			f.Link = append(f.Link, Link{
+				Rel:         FeedFacetLinkRel,
+				Href:        facetLink(listHref, r, facet, v.Value),
+				Type:        FeedAcquisitionLinkType,
+				Title:       v.Title,
+				FacetGroup:  group,
+				ActiveFacet: selected == v.Value,
+				Count:       v.Count,
+			})
+		}
+	}
+}
+
+func (h *Handler) orderTitle(order string) string {
+	switch order {
+	case database.OrderSerie:
+		return h.P.Sprintf("By number in serie")
+	case database.OrderYear:
+		return h.P.Sprintf("By year")
+	case database.OrderAdded:
+		return h.P.Sprintf("Newest first")
+	}
+	return h.P.Sprintf("By title")
+}
+
 func (h *Handler) feedBookEntries(books []*model.Book, f *Feed) {
 	for _, book := range books {
-		authors := h.DB.AuthorsByBookId(book.ID)
-		author := ""
-		for _, a := range authors {
-			author += fmt.Sprint(a.Name, ", ")
-		}
+		h.DB.FillBook(book)
 		entry := &Entry{
 			Title:   book.Title,
 			ID:      fmt.Sprint("/opds/books?id=", book.ID),
-			Updated: f.Time(time.Now()),
-			Link: []Link{
+			Updated: f.Time(time.Unix(book.Updated, 0)),
+			Issued:  book.Year,
+			Link: append(h.acquisitionLinks(book), []Link{
 				{
-					Rel:  "http://opds-spec.org/acquisition/open-access",
-					Href: fmt.Sprint("/opds/books?id=", book.ID),
-					Type: "application/fb2",
+					Rel:   "alternate",
+					Href:  fmt.Sprint("/opds/books?id=", book.ID, "&type=entry"),
+					Type:  EntryType,
+					Title: h.P.Sprintf("Full entry"),
 				},
 				{
 					Rel:  "http://opds-spec.org/image",
 					Href: fmt.Sprint("/opds/covers?cover=", book.ID),
-					Type: mime.TypeByExtension(path.Ext(book.Cover)),
+					Type: coverType(book),
 				},
 				{
 					Rel:  "http://opds-spec.org/image/thumbnail",
 					Href: fmt.Sprint("/opds/covers?thumbnail=", book.ID),
-					Type: mime.TypeByExtension(path.Ext(book.Cover)),
+					Type: coverType(book),
 				},
-			},
-			Authors: []Author{
-				{
-					Name: strings.TrimSuffix(author, ", "),
-				},
-			},
+			}...),
+			Authors:  []Author{},
+			Category: h.genreCategories(book.Genres),
 			Content: &Content{
 				Type:    FeedHtmlContentType,
 				Content: fmt.Sprint(book.Plot),
 			},
+			Book: book,
+		}
+		if book.Size > 0 {
+			entry.Extent = byteSize(book.Size)
+		}
+		if book.Language != nil {
+			entry.Language = book.Language.Code
+		}
+		if book.Serie != nil && book.Serie.Name != "" {
+			title := h.P.Sprintf("Serie: %s", book.Serie.Name)
+			if book.SerieNum > 0 {
+				title = h.P.Sprintf("Serie: %s, book %d", book.Serie.Name, book.SerieNum)
+			}
+			entry.Link = append(entry.Link, Link{Rel: "related", Href: fmt.Sprint("/opds/series?id=", book.Serie.ID), Type: FeedAcquisitionLinkType, Title: title})
+		}
+		for _, a := range book.Authors {
+			href := fmt.Sprint("/opds/authors?id=", a.ID)
+			entry.Authors = append(entry.Authors, Author{Name: a.Name, Uri: href})
+			entry.Link = append(entry.Link, Link{Rel: "related", Href: href, Type: FeedNavigationLinkType, Title: h.P.Sprintf("All books by %s", a.Name)})
 		}
 		f.Entry = append(f.Entry, entry)
 	}
 }
 
+// byteSize returns human readable file size
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// genreCategories returns book genres as entry categories with localised labels
+func (h *Handler) genreCategories(genres []string) []Category {
+	categories := []Category{}
+	for _, g := range genres {
+		categories = append(categories, Category{Term: g, Label: h.genreName(g), Scheme: GenreScheme})
+	}
+	return categories
+}
+
 func (h *Handler) unloadBook(w http.ResponseWriter, r *http.Request) {
 	bookId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
 	book := h.DB.FindBookById(bookId)
@@ -613,28 +954,104 @@ func (h *Handler) unloadBook(w http.ResponseWriter, r *http.Request) {
 		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
 		return
 	}
-	var rc io.ReadCloser
-	if book.Archive == "" {
-		rc, _ = os.Open(path.Join(h.CFG.Library.BOOK_STOCK, book.File))
-	} else {
-		zr, _ := zip.OpenReader(path.Join(h.CFG.Library.BOOK_STOCK, book.Archive))
+	rsc, err := h.seekBook(book)
+	if err != nil {
+		h.LOG.E.Print(err)
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	defer rsc.Close()
+	serveBook(w, r, book, "", rsc, h.bookFileName(book, "fb2"), "application/fb2")
+}
+
+// FB2ZipType is zipped FB2 book media type
+const FB2ZipType = "application/fb2+zip"
+
+// GET /opds/books?id=""&format=fb2.zip - download the book as single entry zip archive.
+// Deflated members of stock archives are passed through without recompression
+func (h *Handler) unloadZipped(w http.ResponseWriter, r *http.Request) {
+	bookId, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	book := h.DB.FindBookById(bookId)
+	if book == nil {
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	if notModified(w, r, book, "fb2.zip") {
+		return
+	}
+	name := h.bookFileName(book, "fb2")
+	rsc, err := h.cachedBook(book, "fb2.zip", ".zip", func(w io.Writer) error {
+		return h.zipBook(book, name, w)
+	})
+	if err != nil {
+		h.LOG.E.Printf("failed to zip book %d: %s\n", book.ID, err)
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	defer rsc.Close()
+	serveBook(w, r, book, "fb2.zip", rsc, name+".zip", FB2ZipType)
+}
+
+// zipBook writes the book as single entry zip archive with the entry name
+func (h *Handler) zipBook(book *model.Book, name string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	if book.Archive != "" {
+		zr, file, err := h.archiveFile(book)
+		if err != nil {
+			return err
+		}
 		defer zr.Close()
-		for _, file := range zr.File {
-			if file.Name == book.File {
-				rc, _ = file.Open()
-				break
+		if file.Method == zip.Deflate {
+			// Entry name is UTF-8 and the stock archive extra fields don't belong to the new entry
+			fh := file.FileHeader
+			fh.Name = name
+			fh.Flags |= 0x800
+			fh.NonUTF8 = false
+			fh.Extra = nil
+			if err := copyRaw(zw, file, &fh); err != nil {
+				return err
 			}
+			return zw.Close()
 		}
 	}
+	rc, err := h.openBook(book)
+	if err != nil {
+		return err
+	}
 	defer rc.Close()
+	if err := copyDeflated(zw, rc, &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Unix(book.Updated, 0)}); err != nil {
+		return err
+	}
+	return zw.Close()
+}
 
-	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", book.File))
-	w.Header().Add("Content-Type", fmt.Sprintf("application/fb2; name=%s", book.File))
-	w.Header().Add("Content-Transfer-Encoding", "binary")
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, rc)
+// copyRaw copies compressed archive member to the zip writer as is
+func copyRaw(zw *zip.Writer, file *zip.File, fh *zip.FileHeader) error {
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return err
+	}
+	fw, err := zw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, raw)
+	return err
 }
 
+// copyDeflated compresses r to the zip writer
+func copyDeflated(zw *zip.Writer, r io.Reader, fh *zip.FileHeader) error {
+	fw, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+// coverCacheControl lets clients keep covers for a week
+const coverCacheControl = "public, max-age=604800"
+
 // Covers
 func (h *Handler) covers(w http.ResponseWriter, r *http.Request) {
 	switch {
@@ -652,61 +1069,226 @@ func (h *Handler) covers(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) unloadCover(w http.ResponseWriter, r *http.Request) {
 	bookId, _ := strconv.ParseInt(r.FormValue("cover"), 10, 64)
-	img := h.getCoverImage(bookId)
-	if img == nil {
-		return
-	}
-	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", "cover.jpg"))
-	w.Header().Add("Content-Type", "image/jpeg")
-	jpeg.Encode(w, img, nil)
+	h.serveCover(w, r, bookId, "cover", h.coverWidth(r.FormValue("size"), ""))
 }
 
 func (h *Handler) unloadThumbnail(w http.ResponseWriter, r *http.Request) {
 	bookId, _ := strconv.ParseInt(r.FormValue("thumbnail"), 10, 64)
-	img := h.getCoverImage(bookId)
-	if img == nil {
-		return
+	h.serveCover(w, r, bookId, "thumbnail", h.coverWidth(r.FormValue("size"), h.CFG.Covers.THUMBNAIL))
+}
+
+// defaultThumbnailWidth is used when there are no thumbnail sizes in config
+const defaultThumbnailWidth = 100
+
+// coverWidth returns cover width of "size" parameter. The size is configured size name or width,
+// otherwise the width of the default size is returned. Zero width means the original cover
+func (h *Handler) coverWidth(size, defaultSize string) uint {
+	sizes := h.CFG.Covers.SIZES
+	if width, ok := sizes[size]; ok {
+		return width
+	}
+	if width, err := strconv.ParseUint(size, 10, 32); err == nil {
+		for _, s := range sizes {
+			if uint64(s) == width {
+				return s
+			}
+		}
 	}
-	img = resize.Resize(100, 0, img, resize.NearestNeighbor)
-	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s", "thumbnail.jpg"))
-	w.Header().Add("Content-Type", "image/jpeg")
-	jpeg.Encode(w, img, nil)
+	if defaultSize == "" {
+		return 0
+	}
+	if width, ok := sizes[defaultSize]; ok {
+		return width
+	}
+	return defaultThumbnailWidth
 }
 
-func (h *Handler) getCoverImage(bookId int64) image.Image {
+// serveCover serves the book cover image of the width, the original width is kept when it is 0.
+// Client cached covers are not decoded again
+func (h *Handler) serveCover(w http.ResponseWriter, r *http.Request, bookId int64, name string, width uint) {
 	book := h.DB.FindBookById(bookId)
 	if book == nil {
-		return nil
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
 	}
-	if book.Cover == "" {
-		return nil
+	variant := name
+	if width > 0 {
+		variant = fmt.Sprint(name, "-", width)
 	}
-	var rc io.ReadCloser
-	if book.Archive == "" {
-		rc, _ = os.Open(path.Join(h.CFG.Library.BOOK_STOCK, book.File))
-	} else {
-		zr, _ := zip.OpenReader(path.Join(h.CFG.Library.BOOK_STOCK, book.Archive))
-		defer zr.Close()
-		for _, file := range zr.File {
-			if file.Name == book.File {
-				rc, _ = file.Open()
-				break
+	w.Header().Set("Cache-Control", coverCacheControl)
+	if notModified(w, r, book, variant) {
+		return
+	}
+	// Covers that can't be decoded are replaced with PNG placeholders, cached with their own extension
+	var rsc io.ReadSeekCloser
+	var err error
+	ext, hasCover := ".png", book.Cover != "" && !isBadCover(book)
+	if hasCover {
+		if e := path.Ext(book.Cover); e != "" {
+			ext = strings.ToLower(e)
+		}
+		rsc, err = h.cachedBook(book, variant, ext, func(w io.Writer) error {
+			data, err := h.coverData(book)
+			if err != nil {
+				return err
 			}
+			return h.resizeCover(w, data, width)
+		})
+		if errors.Is(err, errBadCover) {
+			h.LOG.E.Printf("book %d cover is replaced with placeholder: %s\n", book.ID, err)
+			badCovers.Store(coverKey(book), true)
+			hasCover = false
 		}
 	}
+	if !hasCover {
+		ext = ".png"
+		rsc, err = h.cachedBook(book, variant, ext, func(w io.Writer) error {
+			return h.placeholderCover(w, book, width)
+		})
+	}
+	if err != nil {
+		h.LOG.E.Print(err)
+		writeMessage(w, http.StatusNotFound, h.P.Sprintf("Book not found"))
+		return
+	}
+	defer rsc.Close()
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(rsc, sniff)
+	if _, err := rsc.Seek(0, io.SeekStart); err != nil {
+		h.LOG.E.Print(err)
+		return
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(name+ext))
+	w.Header().Set("Content-Type", http.DetectContentType(sniff[:n]))
+	http.ServeContent(w, r, name+ext, bookModified(book), rsc)
+}
+
+// zipFileCloser closes the zip archive along with the archive file
+type zipFileCloser struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (zfc *zipFileCloser) Close() error {
+	zfc.ReadCloser.Close()
+	return zfc.zr.Close()
+}
+
+// openBook opens book file from the book stock directory or from the stock archive
+func (h *Handler) openBook(book *model.Book) (io.ReadCloser, error) {
+	if book.Archive == "" {
+		return os.Open(path.Join(h.CFG.Library.BOOK_STOCK, book.File))
+	}
+	zr, file, err := h.archiveFile(book)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := file.Open()
+	if err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return &zipFileCloser{ReadCloser: rc, zr: zr}, nil
+}
+
+// archiveFile opens the stock archive of the book and finds the book file in it
+func (h *Handler) archiveFile(book *model.Book) (*zip.ReadCloser, *zip.File, error) {
+	zr, err := zip.OpenReader(path.Join(h.CFG.Library.BOOK_STOCK, book.Archive))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range zr.File {
+		if file.Name == book.File {
+			return zr, file, nil
+		}
+	}
+	zr.Close()
+	return nil, nil, fmt.Errorf("file %s not found in archive %s", book.File, book.Archive)
+}
+
+// resizeCover writes the cover image resized to the width with Lanczos filter. Covers not wider than the width
+// are written as is. PNG and GIF covers keep their format, other ones are written as JPEG of configured quality
+func (h *Handler) resizeCover(w io.Writer, data []byte, width uint) error {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errBadCover, err)
+	}
+	if width == 0 || img.Bounds().Dx() <= int(width) {
+		_, err = w.Write(data)
+		return err
+	}
+	img = resize.Resize(width, 0, img, resize.Lanczos3)
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	}
+	quality := h.CFG.Covers.QUALITY
+	if quality <= 0 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// placeholderWidth is the width of placeholder covers of the original size
+const placeholderWidth = 600
+
+// placeholderCover writes PNG placeholder cover of the width with the book title, author and genre colours
+func (h *Handler) placeholderCover(w io.Writer, book *model.Book, width uint) error {
+	h.DB.FillBook(book)
+	author, genre := "", ""
+	if len(book.Authors) > 0 {
+		author = book.Authors[0].Name
+	}
+	if len(book.Genres) > 0 {
+		genre = book.Genres[0]
+	}
+	if width == 0 {
+		width = placeholderWidth
+	}
+	return png.Encode(w, placeholder.Cover(book.Title, author, genre, int(width), int(width)*3/2))
+}
+
+// errBadCover is the error of the book cover that can't be decoded
+var errBadCover = errors.New("bad cover")
+
+// badCovers are the keys of the books with bad covers, they get placeholders without decoding again
+var badCovers sync.Map
+
+func coverKey(book *model.Book) string {
+	return fmt.Sprintf("%d-%08x", book.ID, book.CRC32)
+}
+
+func isBadCover(book *model.Book) bool {
+	_, bad := badCovers.Load(coverKey(book))
+	return bad
+}
+
+// coverType returns media type of the book cover, placeholder covers are PNG
+func coverType(book *model.Book) string {
+	if book.Cover == "" || isBadCover(book) {
+		return "image/png"
+	}
+	return mime.TypeByExtension(path.Ext(book.Cover))
+}
+
+// coverData returns decoded cover image of the book. Errors of the cover itself wrap errBadCover
+func (h *Handler) coverData(book *model.Book) ([]byte, error) {
+	rc, err := h.openBook(book)
+	if err != nil {
+		return nil, err
+	}
 	defer rc.Close()
 	cover, err := fb2.GetCoverPageBinary(book.Cover, rc)
 	if err != nil {
-		h.LOG.E.Print(err)
-		return nil
+		return nil, fmt.Errorf("%w: %s", errBadCover, err)
 	}
-	br := base64.NewDecoder(base64.StdEncoding, bytes.NewReader(cover.Content))
-	img, _, err := image.Decode(br)
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(cover.Content)))
 	if err != nil {
-		h.LOG.E.Print(err)
-		return nil
+		return nil, fmt.Errorf("%w: %s", errBadCover, err)
 	}
-	return img
+	return data, nil
 }
 
 // utils =======================
@@ -718,10 +1300,12 @@ func NewFeed(title, subtitle, self string) *Feed {
 		XmlnsDC:   "http://purl.org/dc/terms/",
 		XmlnsOS:   "http://a9.com/-/spec/opensearch/1.1/",
 		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		XmlnsThr:  "http://purl.org/syndication/thread/1.0",
 		Title:     title,
 		ID:        self,
 		Link: []Link{
-			{Rel: FeedSearchLinkRel, Href: "/opds/search?q={searchTerms}", Type: FeedSearchLinkType, Title: "Search on catalog"},
+			{Rel: FeedSearchLinkRel, Href: "/opds/opensearch", Type: FeedSearchLinkType, Title: "Search on catalog"},
+			{Rel: FeedSearchLinkRel, Href: "/opds/search?q={searchTerms}", Type: FeedAcquisitionLinkType, Title: "Search on catalog"},
 			{Rel: FeedStartLinkRel, Href: "/opds", Type: FeedNavigationLinkType},
 			{Rel: FeedSelfLinkRel, Href: self, Type: FeedNavigationLinkType},
 		},
@@ -731,12 +1315,70 @@ func NewFeed(title, subtitle, self string) *Feed {
 	return f
 }
 
+// withLanguage returns href with the book language parameter when the language is set
+func withLanguage(href, bookLanguage string) string {
+	if bookLanguage == "" {
+		return href
+	}
+	sep := "?"
+	if strings.Contains(href, "?") {
+		sep = "&"
+	}
+	return href + sep + database.FacetLanguage + "=" + url.QueryEscape(bookLanguage)
+}
+
+// facetParams are the book list facet parameters carried through page links
+var facetParams = []string{"sort", database.FacetLanguage, database.FacetFormat}
+
+// facetHref returns the book list href keeping the facet parameters of the request
+func facetHref(listHref string, r *http.Request) string {
+	for _, p := range facetParams {
+		if v := r.FormValue(p); v != "" {
+			listHref += "&" + p + "=" + url.QueryEscape(v)
+		}
+	}
+	return listHref
+}
+
+// facetLink returns href of the book list first page with the facet parameter set to the value
+// and the other facet parameters of the request kept
+func facetLink(listHref string, r *http.Request, param, value string) string {
+	for _, p := range facetParams {
+		v := r.FormValue(p)
+		if p == param {
+			v = value
+		}
+		if v != "" {
+			listHref += "&" + p + "=" + url.QueryEscape(v)
+		}
+	}
+	return listHref
+}
+
+// pageHref returns the book list page href keeping the facet and page parameters of the request
+func pageHref(listHref string, r *http.Request) string {
+	listHref = facetHref(listHref, r)
+	for _, p := range []string{"after", "before", "last"} {
+		if v := r.FormValue(p); v != "" {
+			return listHref + "&" + p + "=" + url.QueryEscape(v)
+		}
+	}
+	return listHref
+}
+
 func commentURL(comment string, r *http.Request) string {
 	qu, _ := url.QueryUnescape(r.URL.String())
 	return fmt.Sprintf("%s --->URL: [%s]", comment, qu)
 }
 
-func writeFeed(w http.ResponseWriter, statusCode int, f Feed) {
+func writeFeed(w http.ResponseWriter, r *http.Request, statusCode int, f Feed) {
+	if lang := r.FormValue("lang"); lang != "" {
+		f.stickLang(lang)
+	}
+	if isOPDS2(w, r) {
+		writeFeed2(w, statusCode, f)
+		return
+	}
 	data, err := xml.MarshalIndent(f, "", "  ")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,259 @@
+package opds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// OPDS 2.0 feed type
+	Feed2Type = "application/opds+json"
+	// OPDS 2.0 publication metadata type
+	BookSchemaType = "http://schema.org/Book"
+)
+
+type contextKey string
+
+// opds2Key marks requests to OPDS 2.0 catalog tree
+const opds2Key contextKey = "opds2"
+
+// OPDS 2.0 catalog feed, see https://drafts.opds.io/opds-2.0
+type Feed2 struct {
+	Metadata     Metadata2      `json:"metadata"`
+	Links        []Link2        `json:"links"`
+	Navigation   []Link2        `json:"navigation,omitempty"`
+	Facets       []Facet2       `json:"facets,omitempty"`
+	Publications []Publication2 `json:"publications,omitempty"`
+}
+
+type Metadata2 struct {
+	Title         string `json:"title"`
+	Subtitle      string `json:"subtitle,omitempty"`
+	Modified      string `json:"modified,omitempty"`
+	NumberOfItems int64  `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int64  `json:"currentPage,omitempty"`
+}
+
+type Link2 struct {
+	Href       string       `json:"href"`
+	Type       string       `json:"type,omitempty"`
+	Rel        string       `json:"rel,omitempty"`
+	Title      string       `json:"title,omitempty"`
+	Templated  bool         `json:"templated,omitempty"`
+	Properties *Properties2 `json:"properties,omitempty"`
+}
+
+type Properties2 struct {
+	NumberOfItems int64 `json:"numberOfItems,omitempty"`
+}
+
+type Facet2 struct {
+	Metadata Metadata2 `json:"metadata"`
+	Links    []Link2   `json:"links"`
+}
+
+type Publication2 struct {
+	Metadata PublicationMetadata2 `json:"metadata"`
+	Links    []Link2              `json:"links"`
+	Images   []Link2              `json:"images,omitempty"`
+}
+
+type PublicationMetadata2 struct {
+	Type        string         `json:"@type"`
+	Identifier  string         `json:"identifier,omitempty"`
+	Title       string         `json:"title"`
+	Author      []Contributor2 `json:"author,omitempty"`
+	Translator  []Contributor2 `json:"translator,omitempty"`
+	Publisher   string         `json:"publisher,omitempty"`
+	Language    string         `json:"language,omitempty"`
+	Published   string         `json:"published,omitempty"`
+	Modified    string         `json:"modified,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Subject     []Subject2     `json:"subject,omitempty"`
+	BelongsTo   *BelongsTo2    `json:"belongsTo,omitempty"`
+}
+
+type Contributor2 struct {
+	Name  string  `json:"name"`
+	Links []Link2 `json:"links,omitempty"`
+}
+
+type Subject2 struct {
+	Name   string `json:"name"`
+	Code   string `json:"code,omitempty"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+type BelongsTo2 struct {
+	Series []Collection2 `json:"series,omitempty"`
+}
+
+type Collection2 struct {
+	Name     string  `json:"name"`
+	Position int     `json:"position,omitempty"`
+	Links    []Link2 `json:"links,omitempty"`
+}
+
+// isOPDS2 reports whether OPDS 2.0 feed is requested by path or by Accept header.
+// Responses negotiated by Accept header vary on it, so caches keep both feed types apart
+func isOPDS2(w http.ResponseWriter, r *http.Request) bool {
+	if v, ok := r.Context().Value(opds2Key).(bool); ok && v {
+		return true
+	}
+	w.Header().Add("Vary", "Accept")
+	// The one of OPDS 2.0 and Atom types that comes first in Accept header wins
+	accept := r.Header.Get("Accept")
+	i2 := strings.Index(accept, Feed2Type)
+	i1 := strings.Index(accept, "application/atom+xml")
+	return i2 >= 0 && (i1 < 0 || i2 < i1)
+}
+
+func writeFeed2(w http.ResponseWriter, statusCode int, f Feed) {
+	data, err := json.MarshalIndent(NewFeed2(f), "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", Feed2Type)
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// NewFeed2 converts OPDS 1.2 feed to OPDS 2.0 one. Navigation entries become navigation links,
+// acquisition entries become publications and facet links are grouped to facets
+func NewFeed2(f Feed) *Feed2 {
+	f2 := &Feed2{
+		Metadata: Metadata2{
+			Title:         f.Title,
+			Subtitle:      f.Subtitle,
+			Modified:      string(f.Updated),
+			NumberOfItems: f.SearchResult,
+			ItemsPerPage:  f.ItemsPerPage,
+		},
+		Links: []Link2{},
+	}
+	if f.ItemsPerPage > 0 && f.StartIndex > 0 {
+		f2.Metadata.CurrentPage = (f.StartIndex-1)/int64(f.ItemsPerPage) + 1
+	}
+	for _, l := range f.Link {
+		switch {
+		case l.Rel == FeedSearchLinkRel && l.Type == FeedSearchLinkType:
+			f2.Links = append(f2.Links, Link2{Rel: l.Rel, Href: l.Href, Type: l.Type})
+		case l.Rel == FeedSearchLinkRel:
+			f2.Links = append(f2.Links, Link2{Rel: l.Rel, Href: "/opds2/search{?q}", Type: Feed2Type, Templated: true})
+		case l.Rel == FeedFacetLinkRel:
+			f2.addFacet(l)
+		default:
+			f2.Links = append(f2.Links, link2(l))
+		}
+	}
+	for _, e := range f.Entry {
+		if e == nil {
+			continue
+		}
+		if e.Book == nil {
+			for _, l := range e.Link {
+				nl := link2(l)
+				nl.Rel = ""
+				nl.Title = e.Title
+				f2.Navigation = append(f2.Navigation, nl)
+				break
+			}
+			continue
+		}
+		f2.Publications = append(f2.Publications, publication2(e))
+	}
+	return f2
+}
+
+func (f2 *Feed2) addFacet(l Link) {
+	nl := link2(l)
+	nl.Rel = ""
+	if l.ActiveFacet {
+		nl.Rel = FeedSelfLinkRel
+	}
+	if l.Count > 0 {
+		nl.Properties = &Properties2{NumberOfItems: l.Count}
+	}
+	for i := range f2.Facets {
+		if f2.Facets[i].Metadata.Title == l.FacetGroup {
+			f2.Facets[i].Links = append(f2.Facets[i].Links, nl)
+			return
+		}
+	}
+	f2.Facets = append(f2.Facets, Facet2{Metadata: Metadata2{Title: l.FacetGroup}, Links: []Link2{nl}})
+}
+
+func publication2(e *Entry) Publication2 {
+	b := e.Book
+	p := Publication2{
+		Metadata: PublicationMetadata2{
+			Type:        BookSchemaType,
+			Identifier:  fmt.Sprint("urn:flibgo:book:", b.ID),
+			Title:       b.Title,
+			Published:   e.Issued,
+			Modified:    string(e.Updated),
+			Description: b.Plot,
+			Publisher:   e.Publisher,
+		},
+		Links: []Link2{},
+	}
+	if e.Content != nil {
+		p.Metadata.Description = e.Content.Content
+	}
+	for _, t := range e.Contributors {
+		p.Metadata.Translator = append(p.Metadata.Translator, Contributor2{Name: t.Name})
+	}
+	if b.Language != nil {
+		p.Metadata.Language = b.Language.Code
+	}
+	for _, a := range b.Authors {
+		p.Metadata.Author = append(p.Metadata.Author, Contributor2{
+			Name:  a.Name,
+			Links: []Link2{{Href: fmt.Sprint("/opds2/authors?id=", a.ID), Type: Feed2Type}},
+		})
+	}
+	for _, c := range e.Category {
+		p.Metadata.Subject = append(p.Metadata.Subject, Subject2{Name: c.Label, Code: c.Term, Scheme: c.Scheme})
+	}
+	if b.Serie != nil && b.Serie.Name != "" {
+		p.Metadata.BelongsTo = &BelongsTo2{
+			Series: []Collection2{{
+				Name:     b.Serie.Name,
+				Position: b.SerieNum,
+				Links:    []Link2{{Href: fmt.Sprint("/opds2/series?id=", b.Serie.ID), Type: Feed2Type}},
+			}},
+		}
+	}
+	for _, l := range e.Link {
+		switch {
+		case strings.HasPrefix(l.Rel, "http://opds-spec.org/image"):
+			p.Images = append(p.Images, Link2{Href: l.Href, Type: l.Type})
+		default:
+			p.Links = append(p.Links, link2(l))
+		}
+	}
+	return p
+}
+
+// link2 converts OPDS 1.2 link to OPDS 2.0 one. Links to catalog feeds are pointed to OPDS 2.0 tree
+func link2(l Link) Link2 {
+	nl := Link2{Href: l.Href, Type: l.Type, Rel: l.Rel, Title: l.Title}
+	if l.Type == EntryType {
+		nl.Type = Publication2Type
+		nl.Href = "/opds2" + strings.TrimPrefix(nl.Href, "/opds")
+		return nl
+	}
+	if strings.HasPrefix(l.Type, "application/atom+xml") && strings.Contains(l.Type, "profile=opds-catalog") {
+		nl.Type = Feed2Type
+		if strings.HasPrefix(nl.Href, "/opds") && !strings.HasPrefix(nl.Href, "/opds2") {
+			nl.Href = "/opds2" + strings.TrimPrefix(nl.Href, "/opds")
+		}
+	}
+	return nl
+}
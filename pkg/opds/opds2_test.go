@@ -0,0 +1,91 @@
+package opds
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vinser/flibgo/pkg/model"
+)
+
+func TestNewFeed2(t *testing.T) {
+	f := NewFeed("Discworld", "", "/opds/series?id=3")
+	f.SearchResult = 41
+	f.ItemsPerPage = 30
+	f.StartIndex = 31
+	f.Link = append(f.Link, Link{Rel: FeedNextLinkRel, Href: "/opds/series?id=3&after=x", Type: FeedAcquisitionLinkType})
+	f.Entry = []*Entry{
+		{
+			Title: "Authors",
+			Link:  []Link{{Rel: FeedSubsectionLinkRel, Href: "/opds/authors", Type: FeedNavigationLinkType}},
+		},
+		{
+			Title: "Mort",
+			Link: []Link{
+				{Rel: "http://opds-spec.org/acquisition/open-access", Href: "/opds/books?id=4", Type: "application/fb2"},
+				{Rel: "http://opds-spec.org/image", Href: "/opds/covers?cover=4", Type: "image/jpeg"},
+			},
+			Category: []Category{{Term: "sf_humor", Label: "Humor SF", Scheme: GenreScheme}},
+			Book: &model.Book{
+				ID:       4,
+				Title:    "Mort",
+				Language: &model.Language{Code: "en"},
+				Authors:  []*model.Author{{ID: 1, Name: "Terry Pratchett"}},
+				Serie:    &model.Serie{ID: 3, Name: "Discworld"},
+				SerieNum: 4,
+			},
+		},
+	}
+	f2 := NewFeed2(*f)
+	if f2.Metadata.CurrentPage != 2 || f2.Metadata.NumberOfItems != 41 {
+		t.Errorf("Expecting page 2 of 41 items, got: %#v", f2.Metadata)
+	}
+	if len(f2.Navigation) != 1 || f2.Navigation[0].Href != "/opds2/authors" || f2.Navigation[0].Type != Feed2Type {
+		t.Errorf("Expecting navigation to /opds2/authors, got: %#v", f2.Navigation)
+	}
+	if len(f2.Publications) != 1 {
+		t.Fatalf("Expecting 1 publication, got: %d", len(f2.Publications))
+	}
+	p := f2.Publications[0]
+	if p.Metadata.Language != "en" || len(p.Metadata.Author) != 1 || len(p.Metadata.Subject) != 1 {
+		t.Errorf("Unexpected publication metadata: %#v", p.Metadata)
+	}
+	if p.Metadata.BelongsTo == nil || p.Metadata.BelongsTo.Series[0].Position != 4 {
+		t.Errorf("Expecting serie position 4, got: %#v", p.Metadata.BelongsTo)
+	}
+	if len(p.Links) != 1 || len(p.Images) != 1 {
+		t.Errorf("Expecting 1 acquisition link and 1 image, got: %#v %#v", p.Links, p.Images)
+	}
+	for _, l := range f2.Links {
+		if l.Rel == FeedNextLinkRel && l.Href != "/opds2/series?id=3&after=x" {
+			t.Errorf("Expecting next link to OPDS 2.0 tree, got: %s", l.Href)
+		}
+	}
+}
+
+func TestIsOPDS2(t *testing.T) {
+	for _, tc := range []struct {
+		accept       string
+		forced       bool
+		expected     bool
+		expectedVary string
+	}{
+		{"application/opds+json, application/atom+xml", false, true, "Accept"},
+		{"application/atom+xml;q=0.9, application/opds+json", false, false, "Accept"},
+		{"", false, false, "Accept"},
+		{"application/atom+xml", true, true, ""},
+	} {
+		r := httptest.NewRequest("GET", "/opds", nil)
+		r.Header.Set("Accept", tc.accept)
+		if tc.forced {
+			r = r.WithContext(context.WithValue(r.Context(), opds2Key, true))
+		}
+		w := httptest.NewRecorder()
+		if got := isOPDS2(w, r); got != tc.expected {
+			t.Errorf("%q: expecting %v, got: %v", tc.accept, tc.expected, got)
+		}
+		if got := w.Header().Get("Vary"); got != tc.expectedVary {
+			t.Errorf("%q: expecting Vary %q, got: %q", tc.accept, tc.expectedVary, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package opds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+type OpenSearchDescription struct {
+	XMLName        xml.Name          `xml:"OpenSearchDescription"`
+	Xmlns          string            `xml:"xmlns,attr"`
+	XmlnsAtom      string            `xml:"xmlns:atom,attr"`
+	XmlnsParams    string            `xml:"xmlns:parameters,attr"`
+	XmlnsFlibgo    string            `xml:"xmlns:flibgo,attr"`
+	ShortName      string            `xml:"ShortName"`
+	Description    string            `xml:"Description"`
+	Tags           string            `xml:"Tags,omitempty"`
+	Url            []OpenSearchUrl   `xml:"Url"`
+	Query          []OpenSearchQuery `xml:"Query"`
+	Language       string            `xml:"Language,omitempty"`
+	InputEncoding  string            `xml:"InputEncoding"`
+	OutputEncoding string            `xml:"OutputEncoding"`
+}
+
+type OpenSearchUrl struct {
+	Type       string                `xml:"type,attr"`
+	Template   string                `xml:"template,attr"`
+	Method     string                `xml:"parameters:method,attr,omitempty"`
+	Parameters []OpenSearchParameter `xml:"parameters:Parameter"`
+}
+
+type OpenSearchQuery struct {
+	Role        string `xml:"role,attr"`
+	SearchTerms string `xml:"searchTerms,attr"`
+}
+
+type OpenSearchParameter struct {
+	Name    string `xml:"name,attr"`
+	Value   string `xml:"value,attr"`
+	Title   string `xml:"title,attr,omitempty"`
+	Pattern string `xml:"pattern,attr,omitempty"`
+}
+
+// Named search parameters as they are known by /opds/search and fielded query syntax
+var openSearchParameters = []OpenSearchParameter{
+	{Name: "q", Value: "{searchTerms}", Title: "Free text or fielded query like author:Pratchett series:Discworld lang:en year:1990..1999 genre:sf_humor"},
+	{Name: "author", Value: "{atom:author?}", Title: "Author name"},
+	{Name: "title", Value: "{atom:title?}", Title: "Book title"},
+	{Name: "series", Value: "{flibgo:series?}", Title: "Book series"},
+	{Name: "language", Value: "{language?}", Title: "Comma separated book language codes"},
+	{Name: "year", Value: "{flibgo:year?}", Title: "Publication year or years range like 1990..1999", Pattern: `\d{0,4}(\.\.\d{0,4})?`},
+	{Name: "genre", Value: "{flibgo:genre?}", Title: "Comma separated genre codes"},
+}
+
+// GET /opds/opensearch - OpenSearch 1.1 description document
+func (h *Handler) openSearch(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	template := base + "/opds/search?"
+	for i, p := range openSearchParameters {
+		if i > 0 {
+			template += "&"
+		}
+		template += fmt.Sprint(p.Name, "=", p.Value)
+	}
+	suggestTemplate := base + "/opds/suggest?q={searchTerms}"
+	// Keep UI language chosen by the parameter
+	if lang := r.FormValue("lang"); lang != "" {
+		template += "&lang=" + url.QueryEscape(lang)
+		suggestTemplate += "&lang=" + url.QueryEscape(lang)
+	}
+	osd := &OpenSearchDescription{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		XmlnsAtom:   "http://www.w3.org/2005/Atom",
+		XmlnsParams: "http://a9.com/-/spec/opensearch/extensions/parameters/1.0/",
+		XmlnsFlibgo: "https://github.com/vinser/flibgo",
+		ShortName:   h.P.Sprintf("Book catalog"),
+		Description: h.P.Sprintf("Search books by title, author, series, language, year and genre"),
+		Tags:        "opds fb2 books",
+		Url: []OpenSearchUrl{
+			{
+				Type:       FeedAcquisitionLinkType,
+				Template:   template,
+				Method:     "GET",
+				Parameters: openSearchParameters,
+			},
+			{
+				Type:     SuggestionsType,
+				Template: suggestTemplate,
+				Method:   "GET",
+			},
+		},
+		Query:          []OpenSearchQuery{{Role: "example", SearchTerms: "author:Pratchett series:Discworld"}},
+		Language:       h.lang,
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+	}
+	data, err := xml.MarshalIndent(osd, "", "  ")
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.Header().Add("Content-Type", FeedSearchLinkType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header+string(data))
+}
+
+// baseURL returns scheme and host the request was sent to
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,145 @@
+package opds
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vinser/flibgo/pkg/database"
+)
+
+const (
+	// Plain Atom feed type for RSS readers
+	AtomFeedType = "application/atom+xml"
+	// Scan batches shown in new arrivals feeds
+	batchesLimit = 100
+	// Books listed in announcement feed entry
+	announcedBooksLimit = 50
+	// Period of announcement feed in days
+	announcedDays = 30
+)
+
+// New arrivals
+func (h *Handler) newArrivals(w http.ResponseWriter, r *http.Request) {
+	switch {
+	default: // All recent books, newest first
+		h.recentBooks(w, r)
+		h.LOG.D.Println("RecentBooks")
+	case r.FormValue("days") != "":
+		h.listBatches(w, r)
+		h.LOG.D.Println("ListBatches")
+	case r.FormValue("batch") != "":
+		h.batchBooks(w, r)
+		h.LOG.D.Println("BatchBooks")
+	}
+}
+
+// GET /opds/new - all books newest first
+func (h *Handler) recentBooks(w http.ResponseWriter, r *http.Request) {
+	listHref := "/opds/new?all=1"
+	f := NewFeed(h.P.Sprintf("All recent"), "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.RecentBookList(0), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// GET /opds/new?days="" - scan batches of the last days
+func (h *Handler) listBatches(w http.ResponseWriter, r *http.Request) {
+	days, _ := strconv.Atoi(r.FormValue("days"))
+	title := h.P.Sprintf("New this week")
+	if days > 7 {
+		title = h.P.Sprintf("New this month")
+	}
+	f := NewFeed(title, "", fmt.Sprint("/opds/new?days=", days))
+	f.Entry = []*Entry{}
+	since := time.Now().AddDate(0, 0, -days).Unix()
+	for _, b := range h.DB.ListBatches(since, batchesLimit) {
+		href := fmt.Sprint("/opds/new?batch=", b.Updated)
+		entry := &Entry{
+			Title:   h.batchTitle(b),
+			ID:      href,
+			Updated: f.Time(time.Unix(b.Updated, 0)),
+			Link: []Link{
+				{Rel: FeedSubsectionLinkRel, Href: href, Type: FeedAcquisitionLinkType},
+			},
+			Content: &Content{
+				Type:    FeedTextContentType,
+				Content: h.P.Sprintf("Total books - %d", b.Count),
+			},
+		}
+		f.Entry = append(f.Entry, entry)
+	}
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// GET /opds/new?batch="" - books of the scan batch
+func (h *Handler) batchBooks(w http.ResponseWriter, r *http.Request) {
+	batch, _ := strconv.ParseInt(r.FormValue("batch"), 10, 64)
+	listHref := fmt.Sprint("/opds/new?batch=", batch)
+	f := NewFeed(h.batchTitle(&database.Batch{Updated: batch}), "", pageHref(listHref, r))
+	h.pageBooks(f, r, h.DB.BatchBookList(batch), listHref)
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// GET /opds/new/atom - plain Atom feed announcing new arrivals for RSS readers
+func (h *Handler) announceArrivals(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	f := NewFeed(h.P.Sprintf("New books"), "", base+"/opds/new/atom")
+	f.Link = []Link{
+		{Rel: FeedSelfLinkRel, Href: base + "/opds/new/atom", Type: AtomFeedType},
+		{Rel: "alternate", Href: base + "/opds/new?days=30", Type: FeedNavigationLinkType},
+	}
+	f.XmlnsOS, f.XmlnsOPDS, f.XmlnsThr = "", "", ""
+	f.Author = []Author{{Name: "flibgo"}}
+	f.Entry = []*Entry{}
+	since := time.Now().AddDate(0, 0, -announcedDays).Unix()
+	for i, b := range h.DB.ListBatches(since, batchesLimit) {
+		if i == 0 {
+			f.Updated = f.Time(time.Unix(b.Updated, 0))
+		}
+		href := fmt.Sprint(base, "/opds/new?batch=", b.Updated)
+		entry := &Entry{
+			Title:   h.P.Sprintf("New books - %d", b.Count) + ", " + h.batchTitle(b),
+			ID:      fmt.Sprint("urn:flibgo:batch:", b.Updated),
+			Updated: f.Time(time.Unix(b.Updated, 0)),
+			Link: []Link{
+				{Rel: "alternate", Href: href, Type: FeedAcquisitionLinkType},
+			},
+			Content: &Content{
+				Type:    FeedHtmlContentType,
+				Content: h.batchAnnouncement(b, base),
+			},
+		}
+		f.Entry = append(f.Entry, entry)
+	}
+	writeFeed(w, r, http.StatusOK, *f)
+}
+
+// batchAnnouncement returns html list of the batch books with authors
+func (h *Handler) batchAnnouncement(b *database.Batch, base string) string {
+	page := h.DB.BatchBookList(b.Updated).After(nil, announcedBooksLimit)
+	var sb strings.Builder
+	sb.WriteString("<ul>")
+	for _, book := range page.Books {
+		authors := []string{}
+		for _, a := range h.DB.AuthorsByBookId(book.ID) {
+			authors = append(authors, a.Name)
+		}
+		sb.WriteString(fmt.Sprintf(`<li><a href="%s/opds/books?id=%d">%s</a>`, base, book.ID, html.EscapeString(book.Title)))
+		if len(authors) > 0 {
+			sb.WriteString(" - " + html.EscapeString(strings.Join(authors, ", ")))
+		}
+		sb.WriteString("</li>")
+	}
+	sb.WriteString("</ul>")
+	if more := b.Count - int64(len(page.Books)); more > 0 {
+		sb.WriteString("<p>" + html.EscapeString(h.P.Sprintf("And %d more", more)) + "</p>")
+	}
+	return sb.String()
+}
+
+func (h *Handler) batchTitle(b *database.Batch) string {
+	return h.P.Sprintf("Added %s", time.Unix(b.Updated, 0).Format("2006-01-02 15:04"))
+}
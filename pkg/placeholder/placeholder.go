@@ -0,0 +1,159 @@
+// Package placeholder draws covers for the books without one
+package placeholder
+
+import (
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Scheme is placeholder cover colour scheme
+type Scheme struct {
+	Background color.RGBA
+	Accent     color.RGBA
+	Text       color.RGBA
+}
+
+// schemes by genre code prefix
+var schemes = map[string]Scheme{
+	"sf":        {rgb(0x1b, 0x26, 0x4f), rgb(0x4f, 0xc3, 0xf7), rgb(0xe3, 0xf2, 0xfd)},
+	"det":       {rgb(0x21, 0x21, 0x21), rgb(0xc6, 0x28, 0x28), rgb(0xf5, 0xf5, 0xf5)},
+	"thriller":  {rgb(0x21, 0x21, 0x21), rgb(0xc6, 0x28, 0x28), rgb(0xf5, 0xf5, 0xf5)},
+	"prose":     {rgb(0xf3, 0xe9, 0xd2), rgb(0x6d, 0x4c, 0x41), rgb(0x3e, 0x27, 0x23)},
+	"love":      {rgb(0xfc, 0xe4, 0xec), rgb(0xd8, 0x1b, 0x60), rgb(0x88, 0x0e, 0x4f)},
+	"adv":       {rgb(0x1b, 0x5e, 0x20), rgb(0xff, 0xb3, 0x00), rgb(0xf1, 0xf8, 0xe9)},
+	"child":     {rgb(0xff, 0xf5, 0x9d), rgb(0x03, 0x9b, 0xe5), rgb(0x0d, 0x47, 0xa1)},
+	"poetry":    {rgb(0xed, 0xe7, 0xf6), rgb(0x7e, 0x57, 0xc2), rgb(0x31, 0x1b, 0x92)},
+	"antique":   {rgb(0x4e, 0x34, 0x2e), rgb(0xd4, 0xaf, 0x37), rgb(0xfb, 0xe9, 0xe7)},
+	"sci":       {rgb(0xe0, 0xf2, 0xf1), rgb(0x00, 0x89, 0x7b), rgb(0x00, 0x4d, 0x40)},
+	"comp":      {rgb(0x26, 0x32, 0x38), rgb(0x76, 0xff, 0x03), rgb(0xec, 0xef, 0xf1)},
+	"ref":       {rgb(0xec, 0xef, 0xf1), rgb(0x54, 0x6e, 0x7a), rgb(0x26, 0x32, 0x38)},
+	"nonf":      {rgb(0xff, 0xf3, 0xe0), rgb(0xef, 0x6c, 0x00), rgb(0x4e, 0x34, 0x2e)},
+	"religion":  {rgb(0x1a, 0x23, 0x7e), rgb(0xff, 0xd5, 0x4f), rgb(0xe8, 0xea, 0xf6)},
+	"humor":     {rgb(0xff, 0xeb, 0x3b), rgb(0xe6, 0x51, 0x00), rgb(0x3e, 0x27, 0x23)},
+	"home":      {rgb(0xf1, 0xf8, 0xe9), rgb(0x7c, 0xb3, 0x42), rgb(0x33, 0x69, 0x1e)},
+	"adventure": {rgb(0x1b, 0x5e, 0x20), rgb(0xff, 0xb3, 0x00), rgb(0xf1, 0xf8, 0xe9)},
+}
+
+// genreAliases are top level genre codes of the genres tree
+var genreAliases = map[string]string{
+	"detective":  "det",
+	"children":   "child",
+	"dramaturgy": "poetry",
+	"science":    "sci",
+	"computers":  "comp",
+	"reference":  "ref",
+	"nonfiction": "nonf",
+}
+
+func rgb(r, g, b uint8) color.RGBA {
+	return color.RGBA{r, g, b, 0xff}
+}
+
+// GenreScheme returns colour scheme of the genre code. Unknown genres get one of the schemes by the code hash
+func GenreScheme(genre string) Scheme {
+	prefix, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(genre)), "_")
+	if alias, ok := genreAliases[prefix]; ok {
+		prefix = alias
+	}
+	if s, ok := schemes[prefix]; ok {
+		return s
+	}
+	keys := []string{"sf", "prose", "adv", "poetry", "sci", "nonf", "ref"}
+	return schemes[keys[crc32.ChecksumIEEE([]byte(genre))%uint32(len(keys))]]
+}
+
+var (
+	regularFont = mustParse(goregular.TTF)
+	boldFont    = mustParse(gobold.TTF)
+)
+
+func mustParse(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Cover draws placeholder cover of the width and height with the title and the author in the genre colours
+func Cover(title, author, genre string, width, height int) image.Image {
+	s := GenreScheme(genre)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{s.Background}, image.Point{}, draw.Src)
+	band := height / 12
+	draw.Draw(img, image.Rect(0, band, width, band+band/4), &image.Uniform{s.Accent}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, height-band-band/4, width, height-band), &image.Uniform{s.Accent}, image.Point{}, draw.Src)
+
+	margin := width / 10
+	y := band*2 + band/2
+	if author = strings.TrimSpace(author); author != "" {
+		y = drawLines(img, regularFont, float64(width)/16, author, s.Text, margin, y, 3)
+		y += band / 2
+	}
+	if title = strings.TrimSpace(title); title != "" {
+		drawLines(img, boldFont, float64(width)/11, title, s.Text, margin, y, 6)
+	}
+	return img
+}
+
+// drawLines draws the text wrapped by words in centered lines from y down. It returns y below the lines
+func drawLines(img *image.RGBA, f *opentype.Font, size float64, text string, c color.Color, margin, y, maxLines int) int {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return y
+	}
+	defer face.Close()
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	width := img.Bounds().Dx() - 2*margin
+	lines := wrap(d, text, width, maxLines)
+	lineHeight := face.Metrics().Height.Ceil()
+	for _, line := range lines {
+		y += lineHeight
+		d.Dot = fixed.P((img.Bounds().Dx()-d.MeasureString(line).Ceil())/2, y)
+		d.DrawString(line)
+	}
+	return y + lineHeight/3
+}
+
+// wrap splits the text to lines fitting the width. Text exceeding maxLines is ellipsized
+func wrap(d *font.Drawer, text string, width, maxLines int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(text) {
+		// Words wider than the line are cut
+		for d.MeasureString(word).Ceil() > width && len([]rune(word)) > 1 {
+			r := []rune(word)
+			word = string(r[:len(r)-1])
+		}
+		switch {
+		case line == "":
+			line = word
+		case d.MeasureString(line+" "+word).Ceil() <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := []rune(lines[maxLines-1])
+		for len(last) > 0 && d.MeasureString(string(last)+"…").Ceil() > width {
+			last = last[:len(last)-1]
+		}
+		lines[maxLines-1] = strings.TrimSpace(string(last)) + "…"
+	}
+	return lines
+}
@@ -0,0 +1,38 @@
+package placeholder
+
+import (
+	"testing"
+)
+
+func TestGenreScheme(t *testing.T) {
+	if GenreScheme("sf_fantasy") != schemes["sf"] {
+		t.Error("expecting sf scheme for sf_fantasy")
+	}
+	if GenreScheme("detective") != schemes["det"] {
+		t.Error("expecting det scheme for detective")
+	}
+	if GenreScheme("unknown_genre") != GenreScheme("unknown_genre") {
+		t.Error("expecting the same scheme for the same genre")
+	}
+}
+
+func TestCover(t *testing.T) {
+	img := Cover("Очень длинное название книги, которое не помещается в одну строку обложки", "Лев Толстой", "prose_classic", 200, 300)
+	if img.Bounds().Dx() != 200 || img.Bounds().Dy() != 300 {
+		t.Fatalf("expecting 200x300 cover, got: %v", img.Bounds())
+	}
+	s := GenreScheme("prose_classic")
+	text := 0
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 200; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			tr, tg, tb, _ := s.Text.RGBA()
+			if r == tr && g == tg && b == tb {
+				text++
+			}
+		}
+	}
+	if text == 0 {
+		t.Error("expecting text to be drawn")
+	}
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Query is a parsed search query like `author:Pratchett series:Discworld lang:en year:1990..1999 genre:sf_humor`
+type Query struct {
+	Text      string
+	Author    string
+	Title     string
+	Serie     string
+	Languages []string
+	Genres    []string
+	YearFrom  string
+	YearTo    string
+}
+
+// Query field names with their aliases
+var fields = map[string]string{
+	"author":   "author",
+	"a":        "author",
+	"title":    "title",
+	"book":     "title",
+	"t":        "title",
+	"series":   "series",
+	"serie":    "series",
+	"s":        "series",
+	"lang":     "lang",
+	"language": "lang",
+	"l":        "lang",
+	"year":     "year",
+	"y":        "year",
+	"genre":    "genre",
+	"g":        "genre",
+}
+
+// ParseQuery parses query string. Field values with spaces should be quoted: author:"Terry Pratchett".
+// Terms without known field prefix are collected as free text
+func ParseQuery(s string) *Query {
+	q := &Query{}
+	text := []string{}
+	for _, term := range splitTerms(s) {
+		name, value, found := strings.Cut(term, ":")
+		field, known := fields[strings.ToLower(name)]
+		if !found || !known || value == "" {
+			text = append(text, strings.Trim(term, `"`))
+			continue
+		}
+		q.Set(field, strings.Trim(value, `"`))
+	}
+	q.Text = strings.Join(text, " ")
+	return q
+}
+
+// Set sets query field value. Unknown fields are ignored
+func (q *Query) Set(field, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	switch fields[strings.ToLower(field)] {
+	case "author":
+		q.Author = joinWords(q.Author, value)
+	case "title":
+		q.Title = joinWords(q.Title, value)
+	case "series":
+		q.Serie = joinWords(q.Serie, value)
+	case "lang":
+		for _, l := range strings.Split(value, ",") {
+			if l = strings.ToLower(strings.TrimSpace(l)); l != "" {
+				q.Languages = append(q.Languages, l)
+			}
+		}
+	case "genre":
+		for _, g := range strings.Split(value, ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				q.Genres = append(q.Genres, g)
+			}
+		}
+	case "year":
+		from, to, isRange := strings.Cut(value, "..")
+		if !isRange {
+			to = from
+		}
+		q.YearFrom, q.YearTo = onlyDigits(from), onlyDigits(to)
+	}
+}
+
+// IsEmpty reports whether the query has neither free text nor any field
+func (q *Query) IsEmpty() bool {
+	return q.Text == "" && !q.IsFielded()
+}
+
+// IsFielded reports whether the query has any field
+func (q *Query) IsFielded() bool {
+	return q.Author != "" || q.Title != "" || q.Serie != "" || len(q.Languages) > 0 || len(q.Genres) > 0 || q.YearFrom != "" || q.YearTo != ""
+}
+
+// IsAuthorOnly reports whether the query has author field only
+func (q *Query) IsAuthorOnly() bool {
+	return q.Author != "" && q.Text == "" && q.Title == "" && q.Serie == "" && len(q.Languages) == 0 && len(q.Genres) == 0 && q.YearFrom == "" && q.YearTo == ""
+}
+
+// String returns the query in the fielded syntax
+func (q *Query) String() string {
+	terms := []string{}
+	add := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if strings.ContainsAny(value, " \t") {
+			value = fmt.Sprintf("%q", value)
+		}
+		terms = append(terms, field+":"+value)
+	}
+	add("author", q.Author)
+	add("title", q.Title)
+	add("series", q.Serie)
+	add("lang", strings.Join(q.Languages, ","))
+	add("genre", strings.Join(q.Genres, ","))
+	switch {
+	case q.YearFrom != "" && q.YearFrom == q.YearTo:
+		add("year", q.YearFrom)
+	case q.YearFrom != "" || q.YearTo != "":
+		add("year", q.YearFrom+".."+q.YearTo)
+	}
+	if q.Text != "" {
+		terms = append(terms, q.Text)
+	}
+	return strings.Join(terms, " ")
+}
+
+// splitTerms splits string by spaces keeping quoted parts together
+func splitTerms(s string) []string {
+	quoted := false
+	return strings.FieldsFunc(s, func(r rune) bool {
+		if r == '"' {
+			quoted = !quoted
+		}
+		return !quoted && unicode.IsSpace(r)
+	})
+}
+
+func joinWords(s1, s2 string) string {
+	return strings.TrimSpace(s1 + " " + s2)
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,48 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	var testArgs = []struct {
+		arg      string
+		expected Query
+	}{
+		{
+			`author:Pratchett series:Discworld lang:en year:1990..1999 genre:sf_humor`,
+			Query{Author: "Pratchett", Serie: "Discworld", Languages: []string{"en"}, Genres: []string{"sf_humor"}, YearFrom: "1990", YearTo: "1999"},
+		},
+		{
+			`author:"Terry Pratchett" mort`,
+			Query{Text: "mort", Author: "Terry Pratchett"},
+		},
+		{
+			`year:..1950 lang:ru,en война и мир`,
+			Query{Text: "война и мир", Languages: []string{"ru", "en"}, YearTo: "1950"},
+		},
+		{
+			`year:1984 note:x`,
+			Query{Text: "note:x", YearFrom: "1984", YearTo: "1984"},
+		},
+	}
+	for _, ta := range testArgs {
+		got := ParseQuery(ta.arg)
+		if !reflect.DeepEqual(*got, ta.expected) {
+			t.Errorf("ParseQuery(%q): expecting %#v, got: %#v", ta.arg, ta.expected, *got)
+		}
+		if again := ParseQuery(got.String()); !reflect.DeepEqual(again, got) {
+			t.Errorf("ParseQuery(%q).String() is not reversible: %q", ta.arg, got.String())
+		}
+	}
+}
+
+func TestIsAuthorOnly(t *testing.T) {
+	if !ParseQuery("author:Strugatsky").IsAuthorOnly() {
+		t.Error("Expecting author only query")
+	}
+	if ParseQuery("author:Strugatsky lang:ru").IsAuthorOnly() {
+		t.Error("Expecting not author only query")
+	}
+}
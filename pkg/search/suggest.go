@@ -0,0 +1,155 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/vinser/flibgo/pkg/normalize"
+)
+
+// Suggestion kinds
+const (
+	AuthorSuggestion = "author"
+	SerieSuggestion  = "serie"
+	TitleSuggestion  = "title"
+)
+
+type Suggestion struct {
+	Kind   string
+	ID     int64
+	Label  string
+	Weight int // book count for authors and series
+}
+
+// SuggestSource provides suggestions to fill the index with
+type SuggestSource interface {
+	// StockStamp changes each time the book stock changes
+	StockStamp() string
+	ListSuggestions() []*Suggestion
+}
+
+// Prefixes up to shortPrefix runes long have their topSuggestions precomputed,
+// so short prefixes matching most of the catalog don't scan it
+const (
+	shortPrefix    = 3
+	topSuggestions = 32
+)
+
+// SuggestIndex is in-memory prefix index of authors, series and titles
+type SuggestIndex struct {
+	sync.RWMutex
+	stamp string
+	keys  []suggestKey // sorted by key
+	top   map[string][]*Suggestion
+}
+
+type suggestKey struct {
+	key string
+	s   *Suggestion
+}
+
+func NewSuggestIndex() *SuggestIndex {
+	return &SuggestIndex{}
+}
+
+// Refresh rebuilds the index if the book stock was changed since last refresh
+func (si *SuggestIndex) Refresh(src SuggestSource) {
+	stamp := src.StockStamp()
+	si.RLock()
+	actual := si.stamp == stamp
+	si.RUnlock()
+	if actual {
+		return
+	}
+	keys := []suggestKey{}
+	for _, s := range src.ListSuggestions() {
+		// Every word of the label starts a key, so "prat" and "terr" both find "Pratchett, Terry".
+		// Keys are the label key tails sharing its memory, so long titles are not copied word by word
+		label := strings.Join(strings.Fields(normalize.Key(s.Label)), " ")
+		for i := 0; i < len(label); i++ {
+			if i == 0 || label[i-1] == ' ' {
+				keys = append(keys, suggestKey{key: label[i:], s: s})
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	ranked := append([]suggestKey{}, keys...)
+	sort.SliceStable(ranked, func(i, j int) bool { return heavier(ranked[i].s, ranked[j].s) })
+	top := map[string][]*Suggestion{}
+	for _, k := range ranked {
+		for i, n := 0, 0; i < len(k.key) && n < shortPrefix; n++ {
+			_, size := utf8.DecodeRuneInString(k.key[i:])
+			i += size
+			p := k.key[:i]
+			if list := top[p]; len(list) < topSuggestions && !contains(list, k.s) {
+				top[p] = append(list, k.s)
+			}
+		}
+	}
+
+	si.Lock()
+	si.stamp = stamp
+	si.keys = keys
+	si.top = top
+	si.Unlock()
+}
+
+// Suggest returns up to limit suggestions starting with the prefix, the heaviest and the shortest first
+func (si *SuggestIndex) Suggest(prefix string, limit int) []*Suggestion {
+	prefixes := []string{normalize.Key(prefix)}
+	if k := normalize.Key(normalize.ToCyrillic(normalize.Fold(prefix))); k != prefixes[0] {
+		prefixes = append(prefixes, k)
+	}
+	found := map[*Suggestion]bool{}
+	suggestions := []*Suggestion{}
+
+	si.RLock()
+	for _, p := range prefixes {
+		if p == "" {
+			continue
+		}
+		if utf8.RuneCountInString(p) <= shortPrefix && limit <= topSuggestions {
+			for _, s := range si.top[p] {
+				if !found[s] {
+					found[s] = true
+					suggestions = append(suggestions, s)
+				}
+			}
+			continue
+		}
+		i := sort.Search(len(si.keys), func(i int) bool { return si.keys[i].key >= p })
+		for ; i < len(si.keys) && strings.HasPrefix(si.keys[i].key, p); i++ {
+			if s := si.keys[i].s; !found[s] {
+				found[s] = true
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+	si.RUnlock()
+
+	sort.SliceStable(suggestions, func(i, j int) bool { return heavier(suggestions[i], suggestions[j]) })
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// heavier reports whether suggestion a goes before b: the heaviest and the shortest first
+func heavier(a, b *Suggestion) bool {
+	if a.Weight != b.Weight {
+		return a.Weight > b.Weight
+	}
+	return len(a.Label) < len(b.Label)
+}
+
+func contains(suggestions []*Suggestion, s *Suggestion) bool {
+	for _, v := range suggestions {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
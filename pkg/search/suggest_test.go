@@ -0,0 +1,50 @@
+package search
+
+import "testing"
+
+type testSource []*Suggestion
+
+func (ts testSource) StockStamp() string {
+	return "test"
+}
+
+func (ts testSource) ListSuggestions() []*Suggestion {
+	return ts
+}
+
+func TestSuggest(t *testing.T) {
+	si := NewSuggestIndex()
+	si.Refresh(testSource{
+		{Kind: AuthorSuggestion, ID: 1, Label: "Pratchett, Terry", Weight: 41},
+		{Kind: AuthorSuggestion, ID: 2, Label: "Толстой, Лев Николаевич", Weight: 12},
+		{Kind: SerieSuggestion, ID: 3, Label: "Discworld", Weight: 41},
+		{Kind: TitleSuggestion, ID: 4, Label: "The Colour of Magic"},
+		{Kind: TitleSuggestion, ID: 5, Label: "Anna Karenina"},
+	})
+	var testArgs = []struct {
+		prefix   string
+		expected []int64
+	}{
+		{"prat", []int64{1}},
+		{"terr", []int64{1}},
+		{"tolst", []int64{2}},
+		{"толс", []int64{2}},
+		{"disc", []int64{3}},
+		{"magic", []int64{4}},
+		{"t", []int64{1, 2, 4}},
+		{"ma", []int64{4}},
+		{"xyz", []int64{}},
+	}
+	for _, ta := range testArgs {
+		got := si.Suggest(ta.prefix, 10)
+		if len(got) != len(ta.expected) {
+			t.Errorf("Suggest(%q): expecting %d suggestions, got: %d", ta.prefix, len(ta.expected), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i].ID != ta.expected[i] {
+				t.Errorf("Suggest(%q): expecting %v, got: %v", ta.prefix, ta.expected[i], got[i].ID)
+			}
+		}
+	}
+}
@@ -26,6 +26,9 @@ type Handler struct {
 	GT  *genres.GenresTree
 	LOG *rlog.Log
 	SY  Sync
+	// Scan batch start time. It is the updated time of all books added by the scan,
+	// so new arrivals are grouped by batches
+	batch int64
 }
 
 type Sync struct {
@@ -63,6 +66,7 @@ func (h *Handler) ScanDir(reindex bool) error {
 	if err != nil {
 		return err
 	}
+	h.batch = time.Now().Unix()
 	h.SY.WG = &sync.WaitGroup{}
 	h.SY.Quota = make(chan struct{}, h.CFG.Database.MAX_SCAN_THREADS)
 	for _, entry := range entries {
@@ -142,7 +146,7 @@ func (h *Handler) indexFB2SingleFile(path string) {
 		Genres:   p.GetGenres(),
 		Serie:    p.GetSerie(),
 		SerieNum: p.GetSerieNumber(),
-		Updated:  time.Now().Unix(),
+		Updated:  h.batch,
 	}
 	if !h.acceptLanguage(book.Language.Code) {
 		msg := "publication language \"%s\" is configured as not accepted, file %s has been skipped"
@@ -242,7 +246,7 @@ func (h *Handler) indexFB2ArchiveFile(zipName string, file *zip.File) {
 		Genres:   p.GetGenres(),
 		Serie:    p.GetSerie(),
 		SerieNum: p.GetSerieNumber(),
-		Updated:  time.Now().Unix(),
+		Updated:  h.batch,
 	}
 	if !h.acceptLanguage(book.Language.Code) {
 		h.LOG.D.Printf("publication language \"%s\" is not accepted, file %s from %s has been skipped\n", book.Language.Code, file.Name, zipName)